@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"tracking-updater/config"
+)
+
+// configCommand runs the config subcommands; check is the only one
+func configCommand(configPath string, args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config check [flags]\n", os.Args[0])
+		return 2
+	}
+	return configCheck(configPath, args[1:])
+}
+
+// configCheck implements `config check`: it prints the effective
+// configuration and reports every problem found in it. It returns the exit
+// code, 1 when the configuration is invalid.
+func configCheck(configPath string, args []string) int {
+	flags := newFlagSet("config check", "", &configPath)
+	flags.Parse(args)
+
+	cfg, err := config.ReadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	out, err := cfg.Effective()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render configuration: %v\n", err)
+		return 1
+	}
+	fmt.Printf("# Effective configuration of %s\n%s", configPath, out)
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+)
+
+// lookup shows an order with its items, shipments and tracks, as the
+// processor sees it
+func lookup(configPath string, args []string) int {
+	flags := newFlagSet("lookup", "<order>", &configPath)
+	filePath := flags.String("file", "", "Route the lookup as for an order read from this file")
+	asJSON := flags.Bool("json", false, "Print the order and shipments as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return usageError(flags, "lookup takes one order number")
+	}
+	orderNumber := flags.Arg(0)
+
+	cfg, log, ok := setup(configPath, os.Stderr)
+	if !ok {
+		return 1
+	}
+
+	ctx := api.WithOrderItems(context.Background())
+	router := api.NewRouter(&cfg.Magento, log)
+	orders := router.LookupFor(*filePath, orderNumber)
+
+	order, err := orders.GetOrderByIncrementID(ctx, orderNumber)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to look up order %s: %v\n", orderNumber, err)
+		return 1
+	}
+	shipments, err := orders.GetShipmentsByOrderID(ctx, order.EntityID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to look up shipments of order %s: %v\n", orderNumber, err)
+		return 1
+	}
+
+	if *asJSON {
+		if err := printJSON(struct {
+			Order     *model.MagentoOrder     `json:"order"`
+			Shipments []model.MagentoShipment `json:"shipments"`
+		}{order, shipments}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print order: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printOrder(order, shipments)
+	return 0
+}
+
+// printOrder prints an order and its shipments as tables
+func printOrder(order *model.MagentoOrder, shipments []model.MagentoShipment) {
+	fmt.Printf("Order %s (entity %d), status %s\n\n", order.IncrementID, order.EntityID, order.Status)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SKU\tTYPE\tORDERED\tSHIPPED\tTO SHIP")
+	for _, item := range order.Items {
+		fmt.Fprintf(w, "%s\t%s\t%g\t%g\t%g\n", item.SKU, item.ProductType, item.QtyOrdered, item.QtyShipped, item.QtyToShip())
+	}
+	w.Flush()
+
+	if len(shipments) == 0 {
+		fmt.Println("\nNo shipments")
+		return
+	}
+
+	for _, shipment := range shipments {
+		items := make([]string, len(shipment.Items))
+		for i, item := range shipment.Items {
+			items[i] = fmt.Sprintf("%s x%g", item.SKU, item.Qty)
+		}
+		fmt.Printf("\nShipment %s (entity %d): %s\n", shipment.IncrementID, shipment.EntityID, strings.Join(items, ", "))
+
+		if len(shipment.Tracks) == 0 {
+			fmt.Println("  No tracks")
+			continue
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  TRACK NUMBER\tCARRIER\tTITLE\tENTITY")
+		for _, track := range shipment.Tracks {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%d\n", track.TrackNumber, track.CarrierCode, track.Title, track.EntityID)
+		}
+		w.Flush()
+	}
+}
@@ -1,68 +1,118 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"os/signal"
-	"syscall"
 
 	"tracking-updater/config"
-	"tracking-updater/internal/api"
-	"tracking-updater/internal/file"
-	"tracking-updater/internal/processor"
 	"tracking-updater/pkg/logger"
 
 	"github.com/sirupsen/logrus"
 )
 
+// command is a subcommand of the CLI. run receives the -config value given
+// before the command and the arguments after its name, and returns the exit
+// code.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(configPath string, args []string) int
+}
+
+// commands lists the subcommands; serve runs when none is given
+var commands = []command{
+	{"serve", "", "Run the service, processing files from the watched directories", serve},
+	{"process", "<file>", "Process a file once and print its report; exits with 1 if it failed", process},
+	{"validate", "<file>", "Parse and validate a file without calling Magento", validate},
+	{"lookup", "<order>", "Show an order with its shipments and tracks", lookup},
+	{"replay", "<report>", "Process the failed rows of a report again", replay},
+	{"config", "check", "Print the effective configuration and check it", configCommand},
+	{"version", "", "Print the version", printVersion},
+}
+
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", "config.yaml", "Path to config file")
+	flag.Usage = usage
 	flag.Parse()
 
-	// Load configuration
-	cfg, err := config.LoadConfig(*configPath)
-	if err != nil {
-		fmt.Printf("Failed to load configuration: %v\n", err)
-		os.Exit(1)
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	// Set up logger
-	log := logger.Setup(&cfg.Log)
-	log.Info("Starting tracking-updater service")
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(*configPath, args))
+		}
+	}
 
-	// Create Magento API client
-	magentoClient := api.NewMagentoClient(&cfg.Magento, log)
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	// Create CSV processor
-	csvProcessor := processor.NewCSVProcessor(cfg, log, magentoClient)
-	csvProcessor.Start()
-	defer csvProcessor.Stop()
+// usage prints the commands and global flags
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config path] <command> [flags] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-20s %s\n", cmd.name+" "+cmd.args, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
-	// Create file watcher
-	fileWatcher, err := file.NewWatcher(&cfg.FileWatch, log, csvProcessor)
-	if err != nil {
-		log.WithError(err).Fatal("Failed to create file watcher")
+// newFlagSet creates the flag set of a command. -config is accepted after
+// the command name too, defaulting to the value given before it.
+func newFlagSet(name, args string, configPath *string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(configPath, "config", *configPath, "Path to config file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] %s\n\nFlags:\n", os.Args[0], name, args)
+		flags.PrintDefaults()
 	}
+	return flags
+}
 
-	// Start the file watcher
-	if err := fileWatcher.Start(); err != nil {
-		log.WithError(err).Fatal("Failed to start file watcher")
+// usageError reports wrong arguments to a command and returns exit code 2
+func usageError(flags *flag.FlagSet, message string) int {
+	fmt.Fprintf(os.Stderr, "%s\n\n", message)
+	flags.Usage()
+	return 2
+}
+
+// setup loads and validates the configuration and sets up the logger, for
+// every command. Logs go to logOut, so one-shot commands keep stdout for
+// their output.
+func setup(configPath string, logOut io.Writer) (*config.Config, *logrus.Logger, bool) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return nil, nil, false
 	}
-	defer fileWatcher.Stop()
+	return cfg, logger.Setup(&cfg.Log, logOut), true
+}
 
-	log.WithFields(logrus.Fields{
-		"watch_dir":     cfg.FileWatch.Directory,
-		"file_pattern":  cfg.FileWatch.FilePattern,
-		"processed_dir": cfg.FileWatch.ProcessedDir,
-		"failed_dir":    cfg.FileWatch.FailedDir,
-	}).Info("Service started successfully")
+// dryRunFlag adds the -dry-run flag to the flag set of a command
+func dryRunFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("dry-run", false, "Look up and validate rows but only record the changes to Magento in the report; overrides dry_run.enabled")
+}
 
-	// Wait for a signal to shut down
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+// applyDryRun enables dry runs when -dry-run was given
+func applyDryRun(cfg *config.Config, dryRun bool) {
+	if dryRun {
+		cfg.DryRun.Enabled = true
+	}
+}
 
-	log.Info("Shutting down service")
+// printJSON writes a value to stdout as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
 }
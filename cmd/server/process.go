@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+	"tracking-updater/internal/processor"
+
+	"github.com/sirupsen/logrus"
+)
+
+// process processes a file once, without moving it, and prints its report
+func process(configPath string, args []string) int {
+	flags := newFlagSet("process", "<file>", &configPath)
+	dryRun := dryRunFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return usageError(flags, "process takes one file")
+	}
+
+	cfg, log, ok := setup(configPath, os.Stderr)
+	if !ok {
+		return 1
+	}
+	applyDryRun(cfg, *dryRun)
+
+	result, success := processOnce(cfg, flags.Arg(0), nil, log)
+	if result == nil {
+		return 1
+	}
+	if err := printJSON(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print report: %v\n", err)
+		return 1
+	}
+	if !success {
+		return 1
+	}
+	return 0
+}
+
+// replay processes the failed rows of a report again and prints the report
+// with their new results
+func replay(configPath string, args []string) int {
+	flags := newFlagSet("replay", "<report>", &configPath)
+	filePath := flags.String("file", "", "CSV file to replay; defaults to the file named in the report, or its copy in the processed or failed directory")
+	dryRun := dryRunFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return usageError(flags, "replay takes one report")
+	}
+
+	cfg, log, ok := setup(configPath, os.Stderr)
+	if !ok {
+		return 1
+	}
+	applyDryRun(cfg, *dryRun)
+
+	report, err := readReport(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	lines := make(map[int]bool)
+	for _, row := range report.Rows {
+		if row.Outcome != model.OutcomeFailed {
+			continue
+		}
+		if row.Line == 0 {
+			log.WithField("order_number", row.OrderNumber).Warn("Failed row has no line number, it cannot be replayed")
+			continue
+		}
+		lines[row.Line] = true
+	}
+	if len(lines) == 0 {
+		fmt.Fprintln(os.Stderr, "The report has no failed rows to replay")
+		return 0
+	}
+
+	path := *filePath
+	if path == "" {
+		if path = findReportedFile(cfg, report.File); path == "" {
+			fmt.Fprintf(os.Stderr, "File %s not found, pass it with -file\n", report.File)
+			return 1
+		}
+	}
+	log.WithField("file", path).WithField("rows", len(lines)).Info("Replaying failed rows")
+
+	result, _ := processOnce(cfg, path, lines, log)
+	if result == nil {
+		return 1
+	}
+
+	// Replace the replayed rows in the original report
+	replayed := make(map[int]model.RowResult)
+	for _, row := range result.Rows {
+		replayed[row.Line] = row
+	}
+	rows := report.Rows[:0]
+	for _, row := range report.Rows {
+		if _, ok := replayed[row.Line]; ok && lines[row.Line] {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, result.Rows...)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Line < rows[j].Line })
+	report.Rows = rows
+
+	if err := printJSON(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print report: %v\n", err)
+		return 1
+	}
+	if report.Count(model.OutcomeFailed) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// processOnce runs a file through a processor. On SIGINT or SIGTERM it stops
+// before the next row, keeping the checkpoint so the next run resumes there.
+func processOnce(cfg *config.Config, filePath string, lines map[int]bool, log *logrus.Logger) (*model.FileResult, bool) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	router := api.NewRouter(&cfg.Magento, log)
+	p := processor.NewCSVProcessor(cfg, log, router)
+	return p.ProcessOnce(ctx, filePath, lines)
+}
+
+// readReport reads a JSON file report
+func readReport(path string) (*model.FileResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	var report model.FileResult
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// findReportedFile locates the file of a report: where it was read, or in
+// the directories files are moved to afterwards
+func findReportedFile(cfg *config.Config, path string) string {
+	candidates := []string{
+		path,
+		filepath.Join(cfg.FileWatch.FailedDir, cfg.FileWatch.ArchiveName(path)),
+		filepath.Join(cfg.FileWatch.ProcessedDir, cfg.FileWatch.ArchiveName(path)),
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/file"
+	"tracking-updater/internal/health"
+	"tracking-updater/internal/processor"
+	"tracking-updater/internal/server"
+	"tracking-updater/internal/tracing"
+	"tracking-updater/pkg/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// serve runs the service until it receives SIGINT or SIGTERM
+func serve(configPath string, args []string) int {
+	flags := newFlagSet("serve", "", &configPath)
+	dryRun := dryRunFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return usageError(flags, "serve takes no arguments")
+	}
+
+	cfg, log, ok := setup(configPath, os.Stdout)
+	if !ok {
+		return 1
+	}
+	applyDryRun(cfg, *dryRun)
+	log.Info("Starting tracking-updater service")
+
+	// Set up tracing; deferred first so spans of the shutdown are flushed too
+	shutdownTracing, err := tracing.Setup(&cfg.Tracing, log)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.WithError(err).Warn("Failed to flush traces")
+		}
+	}()
+
+	// Serve metrics and health checks
+	checks := health.NewRegistry(cfg.HTTP.Health.Timeout)
+	if cfg.HTTP.Enabled {
+		httpServer := server.NewServer(&cfg.HTTP, checks, log)
+		httpServer.Start()
+		defer httpServer.Stop()
+	}
+
+	// Create Magento API clients, one per configured instance/store
+	magentoRouter := api.NewRouter(&cfg.Magento, log)
+	checks.AddReadiness("magento", magentoRouter.Ping)
+
+	// Create CSV processor
+	csvProcessor := processor.NewCSVProcessor(cfg, log, magentoRouter)
+	csvProcessor.Start()
+	defer csvProcessor.Stop()
+	checks.AddLiveness("workers", csvProcessor.WorkersAlive)
+
+	// Create file watcher
+	fileWatcher, err := file.NewWatcher(&cfg.FileWatch, log, csvProcessor)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to create file watcher")
+	}
+
+	// Start the file watcher
+	if err := fileWatcher.Start(); err != nil {
+		log.WithError(err).Fatal("Failed to start file watcher")
+	}
+	defer fileWatcher.Stop()
+	checks.AddLiveness("watcher", fileWatcher.Alive)
+	addDirectoryChecks(checks, cfg)
+
+	// Apply configuration changes on file writes and on SIGHUP
+	reloader := config.NewReloader(configPath, cfg, log)
+	reloader.Adjust(func(cfg *config.Config) { applyDryRun(cfg, *dryRun) })
+	reloader.OnReload(func(cfg *config.Config) {
+		logger.Reconfigure(log, &cfg.Log)
+		magentoRouter.Reconfigure(&cfg.Magento)
+		csvProcessor.Reconfigure(cfg)
+	})
+	reloader.Watch()
+
+	log.WithFields(logrus.Fields{
+		"watch_dir":     cfg.FileWatch.Directory,
+		"file_pattern":  cfg.FileWatch.FilePattern,
+		"processed_dir": cfg.FileWatch.ProcessedDir,
+		"failed_dir":    cfg.FileWatch.FailedDir,
+	}).Info("Service started successfully")
+
+	// Wait for a signal to shut down, reloading the configuration on SIGHUP
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Info("Received SIGHUP, reloading configuration")
+		reloader.Reload()
+	}
+
+	log.Info("Shutting down service")
+	return 0
+}
+
+// addDirectoryChecks registers readiness checks for the directories files are
+// read from and moved to
+func addDirectoryChecks(checks *health.Registry, cfg *config.Config) {
+	dirs := append(cfg.FileWatch.Directories(), cfg.FileWatch.ProcessedDir, cfg.FileWatch.FailedDir)
+	if cfg.FileWatch.ReportDir != "" {
+		dirs = append(dirs, cfg.FileWatch.ReportDir)
+	}
+
+	minFree := cfg.HTTP.Health.MinFreeDiskMB << 20
+	for _, dir := range dirs {
+		checks.AddReadiness("writable:"+dir, health.DirWritable(dir))
+		if minFree > 0 {
+			checks.AddReadiness("disk:"+dir, health.DiskSpace(dir, minFree))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"tracking-updater/internal/model"
+	"tracking-updater/internal/processor"
+)
+
+// validate parses and validates a file without calling Magento and prints
+// the report, exiting with 1 when a row is invalid
+func validate(configPath string, args []string) int {
+	flags := newFlagSet("validate", "<file>", &configPath)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return usageError(flags, "validate takes one file")
+	}
+
+	cfg, _, ok := setup(configPath, os.Stderr)
+	if !ok {
+		return 1
+	}
+
+	result, err := processor.ValidateFile(cfg, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := printJSON(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print report: %v\n", err)
+		return 1
+	}
+	if result.Count(model.OutcomeFailed) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// printVersion prints the version, and the commit when built from git
+func printVersion(configPath string, args []string) int {
+	flags := newFlagSet("version", "", &configPath)
+	flags.Parse(args)
+
+	details := []string{}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		settings := make(map[string]string)
+		for _, s := range info.Settings {
+			settings[s.Key] = s.Value
+		}
+		if revision := settings["vcs.revision"]; revision != "" {
+			if len(revision) > 12 {
+				revision = revision[:12]
+			}
+			if settings["vcs.modified"] == "true" {
+				revision += "-dirty"
+			}
+			details = append(details, "commit "+revision)
+		}
+		details = append(details, info.GoVersion)
+	}
+
+	fmt.Printf("tracking-updater %s (%s)\n", version, strings.Join(details, ", "))
+	return 0
+}
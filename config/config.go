@@ -1,7 +1,11 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -11,28 +15,234 @@ import (
 type Config struct {
 	Magento   MagentoConfig   `mapstructure:"magento"`
 	FileWatch FileWatchConfig `mapstructure:"file_watch"`
+	Tracking  TrackingConfig  `mapstructure:"tracking"`
 	Log       LogConfig       `mapstructure:"log"`
+	HTTP      HTTPConfig      `mapstructure:"http"`
+	Tracing   TracingConfig   `mapstructure:"tracing"`
+	Shutdown  ShutdownConfig  `mapstructure:"shutdown"`
+	Secrets   SecretsConfig   `mapstructure:"secrets"`
+	DryRun    DryRunConfig    `mapstructure:"dry_run"`
+
+	// envOverrides maps the settings set from environment variables to the
+	// variable names, and secretSources the settings resolved from secret
+	// references to the provider names
+	envOverrides  map[string]string
+	secretSources map[string]string
+}
+
+// EnvOverrides returns the settings taken from environment variables, keyed
+// by setting path, with the name of the variable
+func (c *Config) EnvOverrides() map[string]string {
+	return c.envOverrides
 }
 
 // MagentoConfig holds Magento API configuration
 type MagentoConfig struct {
-	BaseURL      string        `mapstructure:"base_url"`
-	Token        string        `mapstructure:"token"`
-	Timeout      time.Duration `mapstructure:"timeout"`
-	MaxRetries   int           `mapstructure:"max_retries"`
-	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+	BaseURL      string         `mapstructure:"base_url"`
+	Token        string         `mapstructure:"token"`
+	StoreCode    string         `mapstructure:"store_code"`
+	Timeout      time.Duration  `mapstructure:"timeout"`
+	MaxRetries   int            `mapstructure:"max_retries"`
+	RetryBackoff time.Duration  `mapstructure:"retry_backoff"`
+	Cache        CacheConfig    `mapstructure:"cache"`
+	Fields       FieldsConfig   `mapstructure:"fields"`
+	Bulk         BulkConfig     `mapstructure:"bulk"`
+	Lookup       LookupConfig   `mapstructure:"lookup"`
+	Routes       []MagentoRoute `mapstructure:"routes"`
+}
+
+// CacheConfig holds the order and shipment lookup cache configuration
+type CacheConfig struct {
+	TTL       time.Duration `mapstructure:"ttl"`
+	BatchSize int           `mapstructure:"batch_size"`
+}
+
+// FieldsConfig holds the field projections passed as the fields= parameter
+// of order and shipment searches; empty values return full documents
+type FieldsConfig struct {
+	Orders     string `mapstructure:"orders"`
+	OrderItems string `mapstructure:"order_items"` // Orders with their items, for item rows
+	Shipments  string `mapstructure:"shipments"`
+}
+
+// BulkConfig holds the asynchronous bulk API configuration
+type BulkConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	PollTimeout  time.Duration `mapstructure:"poll_timeout"`
+}
+
+// LookupConfig selects how orders and shipments are looked up
+type LookupConfig struct {
+	Backend        string `mapstructure:"backend"`
+	GraphQLURL     string `mapstructure:"graphql_url"`
+	OrdersQuery    string `mapstructure:"orders_query"`
+	ShipmentsQuery string `mapstructure:"shipments_query"`
+}
+
+// MagentoRoute sends orders matching a prefix or files from a source
+// directory to a specific Magento instance or store view
+type MagentoRoute struct {
+	Name        string `mapstructure:"name"`
+	OrderPrefix string `mapstructure:"order_prefix"`
+	SourceDir   string `mapstructure:"source_dir"`
+	BaseURL     string `mapstructure:"base_url"`
+	Token       string `mapstructure:"token"`
+	StoreCode   string `mapstructure:"store_code"`
+	GraphQLURL  string `mapstructure:"graphql_url"`
+}
+
+// ForRoute returns the configuration for a route, falling back to the
+// top-level values for anything the route does not override
+func (c MagentoConfig) ForRoute(route MagentoRoute) MagentoConfig {
+	cfg := c
+	cfg.Routes = nil
+	if route.BaseURL != "" {
+		cfg.BaseURL = route.BaseURL
+		// Another instance has its own GraphQL endpoint
+		cfg.Lookup.GraphQLURL = route.GraphQLURL
+	} else if route.GraphQLURL != "" {
+		cfg.Lookup.GraphQLURL = route.GraphQLURL
+	}
+	if route.Token != "" {
+		cfg.Token = route.Token
+	}
+	if route.StoreCode != "" {
+		cfg.StoreCode = route.StoreCode
+	}
+	return cfg
 }
 
 // FileWatchConfig holds file watching configuration
 type FileWatchConfig struct {
-	Directory       string        `mapstructure:"directory"`
-	FilePattern     string        `mapstructure:"file_pattern"`
-	ProcessedDir    string        `mapstructure:"processed_dir"`
-	FailedDir       string        `mapstructure:"failed_dir"`
-	PollInterval    time.Duration `mapstructure:"poll_interval"`
-	MaxConcurrency  int           `mapstructure:"max_concurrency"`
-	BatchSize       int           `mapstructure:"batch_size"`
-	FileProcessTime time.Duration `mapstructure:"file_process_time"`
+	Directory             string        `mapstructure:"directory"`
+	AdditionalDirectories []string      `mapstructure:"additional_directories"`
+	FilePattern           string        `mapstructure:"file_pattern"`
+	ProcessedDir          string        `mapstructure:"processed_dir"`
+	FailedDir             string        `mapstructure:"failed_dir"`
+	PollInterval          time.Duration `mapstructure:"poll_interval"`
+	MaxConcurrency        int           `mapstructure:"max_concurrency"`
+	BatchSize             int           `mapstructure:"batch_size"`
+	FileProcessTime       time.Duration `mapstructure:"file_process_time"`
+	ReportDir             string        `mapstructure:"report_dir"`
+	CheckpointDir         string        `mapstructure:"checkpoint_dir"`
+}
+
+// Directories returns every directory that should be watched for files
+func (c *FileWatchConfig) Directories() []string {
+	return append([]string{c.Directory}, c.AdditionalDirectories...)
+}
+
+// ArchiveName returns the name a file gets in the processed, failed and
+// report directories. Files from directories other than the main one carry a
+// hash of their directory, as files of the same name may arrive in several.
+func (c *FileWatchConfig) ArchiveName(filePath string) string {
+	name := filepath.Base(filePath)
+	dir := filepath.Dir(filePath)
+	if filepath.Clean(dir) == filepath.Clean(c.Directory) {
+		return name
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = filepath.Clean(dir)
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+}
+
+// TrackingConfig controls how tracking rows are applied in Magento
+type TrackingConfig struct {
+	NotifyCustomer      bool              `mapstructure:"notify_customer"`
+	ShipmentStrategy    string            `mapstructure:"shipment_strategy"`
+	Comment             CommentConfig     `mapstructure:"comment"`
+	OrderStatus         OrderStatusConfig `mapstructure:"order_status"`
+	Carriers            CarriersConfig    `mapstructure:"carriers"`
+	CarrierAliases      []CarrierAlias    `mapstructure:"carrier_aliases"`
+	DetectCarrier       bool              `mapstructure:"detect_carrier"`
+	TrackingNumberCheck string            `mapstructure:"tracking_number_check"` // reject, warn or off
+}
+
+// CarrierAlias maps the names partners use for a carrier to its Magento
+// carrier code and canonical title
+type CarrierAlias struct {
+	Code  string   `mapstructure:"code"`
+	Title string   `mapstructure:"title"`
+	Names []string `mapstructure:"names"`
+}
+
+// CarriersConfig controls validation of carrier codes against the carriers
+// configured in Magento
+type CarriersConfig struct {
+	Validate        bool          `mapstructure:"validate"`
+	Endpoint        string        `mapstructure:"endpoint"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Known           []string      `mapstructure:"known"`
+	UnknownToCustom bool          `mapstructure:"unknown_to_custom"`
+}
+
+// OrderStatusConfig holds the rules on the status of orders receiving
+// tracking, and the optional status change once tracking was added
+type OrderStatusConfig struct {
+	Allowed       []string            `mapstructure:"allowed"`
+	Skip          []string            `mapstructure:"skip"`
+	Reject        []string            `mapstructure:"reject"`
+	AfterTracking AfterTrackingConfig `mapstructure:"after_tracking"`
+}
+
+// AfterTrackingConfig describes the order comment, and status change, posted
+// after tracking was added to an order
+type AfterTrackingConfig struct {
+	Status         string `mapstructure:"status"`
+	Comment        string `mapstructure:"comment"`
+	NotifyCustomer bool   `mapstructure:"notify_customer"`
+	VisibleOnFront bool   `mapstructure:"visible_on_front"`
+}
+
+// CommentConfig controls the shipment comment posted after a track is added
+type CommentConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	Template       string `mapstructure:"template"`
+	VisibleOnFront bool   `mapstructure:"visible_on_front"`
+	NotifyCustomer bool   `mapstructure:"notify_customer"`
+}
+
+// HTTPConfig holds the configuration of the operational HTTP server
+type HTTPConfig struct {
+	Enabled     bool         `mapstructure:"enabled"`
+	Address     string       `mapstructure:"address"`
+	MetricsPath string       `mapstructure:"metrics_path"`
+	Health      HealthConfig `mapstructure:"health"`
+}
+
+// HealthConfig holds the configuration of the health and readiness checks
+type HealthConfig struct {
+	Timeout       time.Duration `mapstructure:"timeout"`
+	MinFreeDiskMB uint64        `mapstructure:"min_free_disk_mb"`
+}
+
+// ShutdownConfig controls how the service stops
+type ShutdownConfig struct {
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+}
+
+// DryRunConfig controls dry runs, which look up and validate rows but record
+// the changes they would make to Magento instead of making them
+type DryRunConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	CopyDir string `mapstructure:"copy_dir"` // Files are copied here; they always stay in place
+}
+
+// TracingConfig holds the OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Exporter    string  `mapstructure:"exporter"` // otlp or stdout
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	ServiceName string  `mapstructure:"service_name"`
+	SampleRatio float64 `mapstructure:"sample_ratio"`
 }
 
 // LogConfig holds logging configuration
@@ -43,8 +253,21 @@ type LogConfig struct {
 	EnableFile bool   `mapstructure:"enable_file"`
 }
 
-// LoadConfig loads application configuration
+// LoadConfig loads the application configuration and validates it
 func LoadConfig(filePath string) (*Config, error) {
+	config, err := ReadConfig(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ReadConfig reads the configuration file merged with the defaults and the
+// environment, without validating it
+func ReadConfig(filePath string) (*Config, error) {
 	v := viper.New()
 	v.SetConfigFile(filePath)
 
@@ -56,16 +279,24 @@ func LoadConfig(filePath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Watch for config changes
-	v.WatchConfig()
-
-	// Load environment variables
-	v.AutomaticEnv()
+	// Load environment variables, e.g. TRACKING_UPDATER_MAGENTO_TOKEN
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	overrides, err := bindEnv(v)
+	if err != nil {
+		return nil, err
+	}
 
 	var config Config
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	config.envOverrides = overrides
+
+	// Replace secret references with the secrets
+	if err := config.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
 
 	return &config, nil
 }
@@ -76,6 +307,16 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("magento.timeout", 30*time.Second)
 	v.SetDefault("magento.max_retries", 3)
 	v.SetDefault("magento.retry_backoff", 1*time.Second)
+	v.SetDefault("magento.cache.ttl", 5*time.Minute)
+	v.SetDefault("magento.cache.batch_size", 100)
+	v.SetDefault("magento.bulk.enabled", false)
+	v.SetDefault("magento.bulk.batch_size", 1000)
+	v.SetDefault("magento.bulk.poll_interval", 5*time.Second)
+	v.SetDefault("magento.bulk.poll_timeout", 10*time.Minute)
+	v.SetDefault("magento.lookup.backend", "rest")
+	v.SetDefault("magento.fields.orders", "items[entity_id,increment_id,status],total_count")
+	v.SetDefault("magento.fields.order_items", "items[entity_id,increment_id,status,items[item_id,parent_item_id,sku,product_type,qty_ordered,qty_shipped,qty_refunded,qty_canceled]],total_count")
+	v.SetDefault("magento.fields.shipments", "items[entity_id,increment_id,order_id,items[order_item_id,sku,qty],tracks[entity_id,order_id,parent_id,track_number,title,carrier_code]],total_count")
 
 	// File watching defaults
 	v.SetDefault("file_watch.file_pattern", "^\\d{8}_\\d{6}\\.csv$")
@@ -84,8 +325,49 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("file_watch.batch_size", 50)
 	v.SetDefault("file_watch.file_process_time", 10*time.Minute)
 
+	// Tracking defaults
+	v.SetDefault("tracking.notify_customer", false)
+	v.SetDefault("tracking.shipment_strategy", "first")
+	v.SetDefault("tracking.comment.enabled", false)
+	v.SetDefault("tracking.comment.template", "Tracking {{.TrackingNumber}} added by tracking-updater from file {{.File}}")
+	v.SetDefault("tracking.comment.visible_on_front", false)
+	v.SetDefault("tracking.comment.notify_customer", false)
+	v.SetDefault("tracking.carriers.validate", false)
+	v.SetDefault("tracking.carriers.endpoint", "carriers")
+	v.SetDefault("tracking.carriers.refresh_interval", 1*time.Hour)
+	v.SetDefault("tracking.carriers.unknown_to_custom", false)
+	v.SetDefault("tracking.detect_carrier", false)
+	v.SetDefault("tracking.tracking_number_check", "off")
+	v.SetDefault("tracking.order_status.after_tracking.comment", "Tracking added by tracking-updater")
+
 	// Logging defaults
 	v.SetDefault("log.level", "info")
 	v.SetDefault("log.format", "text")
 	v.SetDefault("log.enable_file", false)
+
+	// HTTP server defaults
+	v.SetDefault("http.enabled", false)
+	v.SetDefault("http.address", ":9090")
+	v.SetDefault("http.metrics_path", "/metrics")
+	v.SetDefault("http.health.timeout", 5*time.Second)
+	v.SetDefault("http.health.min_free_disk_mb", 100)
+
+	// Shutdown defaults
+	v.SetDefault("shutdown.grace_period", 30*time.Second)
+
+	// Dry run defaults
+	v.SetDefault("dry_run.enabled", false)
+
+	// Secret provider defaults
+	v.SetDefault("secrets.vault.mount", "secret")
+	v.SetDefault("secrets.vault.kv_version", 2)
+	v.SetDefault("secrets.vault.timeout", 10*time.Second)
+
+	// Tracing defaults
+	v.SetDefault("tracing.enabled", false)
+	v.SetDefault("tracing.exporter", "otlp")
+	v.SetDefault("tracing.endpoint", "localhost:4318")
+	v.SetDefault("tracing.insecure", false)
+	v.SetDefault("tracing.service_name", "tracking-updater")
+	v.SetDefault("tracing.sample_ratio", 1.0)
 }
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// durationType is rendered as a duration string rather than nanoseconds
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Effective renders the configuration as YAML in the layout of the
+// configuration file, with defaults and environment overrides applied.
+// Secrets are redacted, and settings taken from the environment or from a
+// secret provider are marked with a comment naming the source.
+func (c *Config) Effective() ([]byte, error) {
+	node, err := c.yamlNode("", reflect.ValueOf(*c))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlNode converts a configuration value to a YAML node
+func (c *Config) yamlNode(path string, value reflect.Value) (*yaml.Node, error) {
+	switch {
+	case value.Type() == durationType:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value.Interface().(time.Duration).String()}, nil
+
+	case value.Kind() == reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for i := 0; i < value.NumField(); i++ {
+			name := value.Type().Field(i).Tag.Get("mapstructure")
+			if name == "" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+
+			child, err := c.yamlNode(fieldPath, value.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			if env, ok := c.envOverrides[fieldPath]; ok {
+				child.LineComment = "from " + env
+			}
+			if provider, ok := c.secretSources[fieldPath]; ok {
+				child.LineComment = "from " + provider + " secret"
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, child)
+		}
+		return node, nil
+
+	case value.Kind() == reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		if value.Len() == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		for i := 0; i < value.Len(); i++ {
+			child, err := c.yamlNode(fmt.Sprintf("%s[%d]", path, i), value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	}
+
+	v := value.Interface()
+	if _, ok := c.secretSources[path]; ok || isSecret(path) {
+		v = redact(value.String())
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	return node, nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// envPrefix is prepended to the environment variables overriding settings,
+// e.g. TRACKING_UPDATER_MAGENTO_TOKEN for magento.token
+const envPrefix = "TRACKING_UPDATER"
+
+// fileSuffix marks environment variables naming a file that holds the value,
+// e.g. TRACKING_UPDATER_MAGENTO_TOKEN_FILE=/run/secrets/magento_token
+const fileSuffix = "_FILE"
+
+// envAliases lists the conventional variables also accepted for a setting,
+// after the prefixed one
+var envAliases = map[string][]string{
+	"secrets.vault.address": {"VAULT_ADDR"},
+	"secrets.vault.token":   {"VAULT_TOKEN"},
+}
+
+// envVar returns the environment variable overriding a setting
+func envVar(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// bindEnv binds every setting to its environment variables, so settings
+// missing from the file and the defaults can be set from the environment
+// too, and applies the *_FILE variables. It returns the variable each
+// overridden setting was taken from.
+func bindEnv(v *viper.Viper) (map[string]string, error) {
+	overrides := make(map[string]string)
+
+	for _, key := range settingKeys("", reflect.TypeOf(Config{})) {
+		names := append([]string{envVar(key)}, envAliases[key]...)
+		if err := v.BindEnv(append([]string{key}, names...)...); err != nil {
+			return nil, fmt.Errorf("failed to bind %s to the environment: %w", key, err)
+		}
+
+		for _, name := range names {
+			if _, ok := os.LookupEnv(name); ok {
+				overrides[key] = name
+				break
+			}
+		}
+
+		// The value is read from a file, as Docker and Kubernetes mount secrets
+		name := envVar(key) + fileSuffix
+		path, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if env, ok := overrides[key]; ok {
+			return nil, fmt.Errorf("%s: both %s and %s are set", key, env, name)
+		}
+		value, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", key, name, err)
+		}
+		v.Set(key, value)
+		overrides[key] = name
+	}
+
+	return overrides, nil
+}
+
+// settingKeys returns the paths of the settings of a configuration struct.
+// Lists of sections, such as routes, can only be set in the file.
+func settingKeys(prefix string, t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			keys = append(keys, settingKeys(name, field.Type)...)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+		default:
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// readSecretFile reads a value from a file, without the trailing newline
+// editors and echo leave
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
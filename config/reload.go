@@ -0,0 +1,220 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// reloadable lists the settings a reload applies without a restart, by path
+// with slice indexes removed. A trailing dot covers a whole section.
+var reloadable = []string{
+	"log.level",
+	"log.format",
+	"magento.token",
+	"magento.timeout",
+	"magento.max_retries",
+	"magento.retry_backoff",
+	"magento.routes.token",
+	"file_watch.max_concurrency",
+	"tracking.",
+	"shutdown.grace_period",
+}
+
+// restartOnly lists settings within reloadable sections that still require a
+// restart
+var restartOnly = []string{
+	"tracking.carriers.",
+}
+
+// indexPattern matches the slice indexes in setting paths
+var indexPattern = regexp.MustCompile(`\[\d+\]`)
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// RequiresRestart reports whether the changed setting only takes effect after
+// a restart
+func (c Change) RequiresRestart() bool {
+	path := indexPattern.ReplaceAllString(c.Path, "")
+	if matchesAny(path, restartOnly) {
+		return true
+	}
+	return !matchesAny(path, reloadable)
+}
+
+// matchesAny reports whether a path equals one of the settings, or falls in
+// one of the sections ending with a dot
+func matchesAny(path string, settings []string) bool {
+	for _, setting := range settings {
+		if path == setting || strings.HasSuffix(setting, ".") && strings.HasPrefix(path, setting) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the settings that differ between two configurations, named by
+// their configuration file path. Secret values are redacted.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValues("", reflect.ValueOf(*old), reflect.ValueOf(*new), &changes)
+
+	for i := range changes {
+		change := &changes[i]
+		_, oldSecret := old.secretSources[change.Path]
+		_, newSecret := new.secretSources[change.Path]
+		if oldSecret || newSecret || isSecret(change.Path) {
+			change.Old, change.New = redact(change.Old), redact(change.New)
+		}
+	}
+	return changes
+}
+
+// diffValues compares structs field by field and slices of equal length
+// element by element, and records any other difference as a change
+func diffValues(path string, old, new reflect.Value, changes *[]Change) {
+	switch {
+	case old.Kind() == reflect.Struct:
+		for i := 0; i < old.NumField(); i++ {
+			name := old.Type().Field(i).Tag.Get("mapstructure")
+			if name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValues(name, old.Field(i), new.Field(i), changes)
+		}
+		return
+	case old.Kind() == reflect.Slice && old.Len() == new.Len() && old.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < old.Len(); i++ {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), old.Index(i), new.Index(i), changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(old.Interface(), new.Interface()) {
+		return
+	}
+	*changes = append(*changes, Change{Path: path, Old: fmt.Sprint(old.Interface()), New: fmt.Sprint(new.Interface())})
+}
+
+// isSecret reports whether a setting holds a credential
+func isSecret(path string) bool {
+	return strings.HasSuffix(path, "token")
+}
+
+// redact hides a secret value, keeping whether it is set
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// Reloader reloads the configuration file when it changes or on request, and
+// hands configurations that only change reloadable settings to the handlers
+type Reloader struct {
+	path     string
+	logger   *logrus.Logger
+	mutex    sync.Mutex
+	current  *Config
+	adjust   []func(*Config)
+	handlers []func(*Config)
+}
+
+// NewReloader creates a reloader for the configuration loaded from path
+func NewReloader(path string, current *Config, logger *logrus.Logger) *Reloader {
+	return &Reloader{
+		path:    path,
+		logger:  logger,
+		current: current,
+	}
+}
+
+// Adjust registers a function applied to every reloaded configuration before
+// it is compared, to keep settings given on the command line
+func (r *Reloader) Adjust(adjust func(*Config)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.adjust = append(r.adjust, adjust)
+}
+
+// OnReload registers a handler called with every applied configuration
+func (r *Reloader) OnReload(handler func(*Config)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers = append(r.handlers, handler)
+}
+
+// Watch reloads the configuration whenever the file is written
+func (r *Reloader) Watch() {
+	v := viper.New()
+	v.SetConfigFile(r.path)
+	v.OnConfigChange(func(event fsnotify.Event) {
+		r.logger.WithField("file", event.Name).Info("Configuration file changed, reloading")
+		r.Reload()
+	})
+	v.WatchConfig()
+}
+
+// Reload reads the configuration file and applies it if it is valid and
+// only changes reloadable settings. Otherwise the running configuration is
+// kept and the returned error says why.
+func (r *Reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cfg, err := LoadConfig(r.path)
+	if err != nil {
+		r.logger.WithError(err).Error("Invalid configuration, keeping the running configuration")
+		return err
+	}
+	for _, adjust := range r.adjust {
+		adjust(cfg)
+	}
+
+	changes := Diff(r.current, cfg)
+	if len(changes) == 0 {
+		r.logger.Info("Configuration unchanged")
+		return nil
+	}
+
+	var restart []string
+	for _, change := range changes {
+		if change.RequiresRestart() {
+			restart = append(restart, change.Path)
+		}
+	}
+	if len(restart) > 0 {
+		err := fmt.Errorf("changed settings require a restart: %s", strings.Join(restart, ", "))
+		r.logger.WithError(err).Error("Configuration not reloaded, restart the service to apply it")
+		return err
+	}
+
+	for _, change := range changes {
+		r.logger.WithFields(logrus.Fields{
+			"setting": change.Path,
+			"old":     change.Old,
+			"new":     change.New,
+		}).Info("Configuration setting changed")
+	}
+
+	r.current = cfg
+	for _, handler := range r.handlers {
+		handler(cfg)
+	}
+	r.logger.WithField("changes", len(changes)).Info("Configuration reloaded")
+	return nil
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretPrefix marks a setting value as a reference to a secret, resolved by
+// the provider named next: secret:<provider>:<reference>
+const secretPrefix = "secret:"
+
+// SecretProvider resolves references to secrets kept outside the
+// configuration file
+type SecretProvider interface {
+	Resolve(reference string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to a SecretProvider
+type SecretProviderFunc func(reference string) (string, error)
+
+// Resolve implements SecretProvider
+func (f SecretProviderFunc) Resolve(reference string) (string, error) {
+	return f(reference)
+}
+
+var (
+	providersMutex sync.Mutex
+	providers      = make(map[string]SecretProvider)
+)
+
+// RegisterSecretProvider makes a provider available to secret references
+// in configurations loaded afterwards. It replaces the built-in file, env
+// and vault providers when registered under their name.
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	providers[name] = provider
+}
+
+// SecretsConfig configures the secret providers
+type SecretsConfig struct {
+	Vault VaultConfig `mapstructure:"vault"`
+}
+
+// VaultConfig configures the HashiCorp Vault KV secrets engine provider.
+// References are <path>#<key>, relative to the mount.
+type VaultConfig struct {
+	Address   string        `mapstructure:"address"`
+	Token     string        `mapstructure:"token"`
+	Mount     string        `mapstructure:"mount"`
+	KVVersion int           `mapstructure:"kv_version"` // 1 or 2
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+// fileProvider reads a secret from a file
+func fileProvider(reference string) (string, error) {
+	return readSecretFile(reference)
+}
+
+// envProvider reads a secret from an environment variable
+func envProvider(reference string) (string, error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", reference)
+	}
+	return value, nil
+}
+
+// vaultProvider reads secrets from a Vault KV secrets engine, fetching each
+// secret once per configuration load
+type vaultProvider struct {
+	config  *VaultConfig
+	client  *http.Client
+	secrets map[string]map[string]interface{}
+}
+
+// newVaultProvider creates a Vault provider
+func newVaultProvider(cfg *VaultConfig) *vaultProvider {
+	return &vaultProvider{
+		config:  cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		secrets: make(map[string]map[string]interface{}),
+	}
+}
+
+// Resolve implements SecretProvider
+func (p *vaultProvider) Resolve(reference string) (string, error) {
+	path, key, ok := strings.Cut(reference, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault reference %q must be <path>#<key>", reference)
+	}
+
+	data, ok := p.secrets[path]
+	if !ok {
+		var err error
+		if data, err = p.read(path); err != nil {
+			return "", err
+		}
+		p.secrets[path] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// read fetches the key/value pairs of a secret
+func (p *vaultProvider) read(path string) (map[string]interface{}, error) {
+	if p.config.Address == "" {
+		return nil, fmt.Errorf("secrets.vault.address is not set")
+	}
+
+	mount := strings.Trim(p.config.Mount, "/")
+	path = strings.Trim(path, "/")
+	url := fmt.Sprintf("%s/v1/%s/%s", strings.TrimRight(p.config.Address, "/"), mount, path)
+	if p.config.KVVersion != 1 {
+		url = fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(p.config.Address, "/"), mount, path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.config.Token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read vault secret %s: status %d", path, resp.StatusCode)
+	}
+
+	// KV version 2 nests the key/value pairs in data.data
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode vault secret %s: %w", path, err)
+	}
+	if p.config.KVVersion != 1 {
+		data, _ := response.Data["data"].(map[string]interface{})
+		return data, nil
+	}
+	return response.Data, nil
+}
+
+// resolveSecrets replaces the secret references in string settings with the
+// secrets they point to. The secrets section is resolved first, with the
+// file and env providers only, so the Vault token can be a reference too.
+func (c *Config) resolveSecrets() error {
+	c.secretSources = make(map[string]string)
+
+	local := map[string]SecretProvider{
+		"file": SecretProviderFunc(fileProvider),
+		"env":  SecretProviderFunc(envProvider),
+	}
+	secrets := reflect.ValueOf(&c.Secrets).Elem()
+	if err := c.resolveValue("secrets", secrets, local); err != nil {
+		return err
+	}
+
+	all := map[string]SecretProvider{
+		"file":  local["file"],
+		"env":   local["env"],
+		"vault": newVaultProvider(&c.Secrets.Vault),
+	}
+	providersMutex.Lock()
+	for name, provider := range providers {
+		all[name] = provider
+	}
+	providersMutex.Unlock()
+
+	return c.resolveValue("", reflect.ValueOf(c).Elem(), all)
+}
+
+// resolveValue resolves the secret references in the strings of a value
+func (c *Config) resolveValue(path string, value reflect.Value, providers map[string]SecretProvider) error {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			name := value.Type().Field(i).Tag.Get("mapstructure")
+			if name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := c.resolveValue(name, value.Field(i), providers); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if err := c.resolveValue(fmt.Sprintf("%s[%d]", path, i), value.Index(i), providers); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		reference, ok := strings.CutPrefix(value.String(), secretPrefix)
+		if !ok {
+			return nil
+		}
+		name, reference, _ := strings.Cut(reference, ":")
+		provider, ok := providers[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown secret provider %q", path, name)
+		}
+		secret, err := provider.Resolve(reference)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		value.SetString(secret)
+		c.secretSources[path] = name
+	}
+	return nil
+}
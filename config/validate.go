@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FieldError is a problem with one setting, named by its configuration path
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("invalid configuration, %d problem(s):\n%s", len(e.Errors), strings.Join(lines, "\n"))
+}
+
+// validator collects the problems of a configuration
+type validator struct {
+	errs []*FieldError
+}
+
+// add records a problem with a setting
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// required records a problem when a setting is empty
+func (v *validator) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+	}
+}
+
+// url records a problem when a setting is not an absolute http(s) URL
+func (v *validator) url(field, value string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add(field, "is not a valid URL: %v", err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(field, "must be an absolute http or https URL, got %q", value)
+	}
+}
+
+// oneOf records a problem when a setting has none of the allowed values
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+// Validate checks the configuration and reports every problem at once as a
+// *ValidationError
+func (c *Config) Validate() error {
+	v := &validator{}
+	c.validateMagento(v)
+	c.validateFileWatch(v)
+	c.validateTracking(v)
+
+	if _, err := logrus.ParseLevel(strings.ToLower(c.Log.Level)); err != nil {
+		v.add("log.level", "unknown level %q", c.Log.Level)
+	}
+	v.oneOf("log.format", strings.ToLower(c.Log.Format), "text", "json")
+	if c.Log.EnableFile {
+		v.required("log.file", c.Log.File)
+	}
+
+	if c.HTTP.Enabled {
+		v.required("http.address", c.HTTP.Address)
+		if !strings.HasPrefix(c.HTTP.MetricsPath, "/") {
+			v.add("http.metrics_path", "must start with /, got %q", c.HTTP.MetricsPath)
+		}
+		if c.HTTP.Health.Timeout <= 0 {
+			v.add("http.health.timeout", "must be positive")
+		}
+	}
+
+	if c.Tracing.Enabled {
+		v.oneOf("tracing.exporter", c.Tracing.Exporter, "otlp", "stdout")
+		if c.Tracing.Exporter == "otlp" {
+			v.required("tracing.endpoint", c.Tracing.Endpoint)
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			v.add("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+		}
+	}
+
+	if v1, v2 := c.Secrets.Vault.KVVersion == 1, c.Secrets.Vault.KVVersion == 2; !v1 && !v2 {
+		v.add("secrets.vault.kv_version", "must be 1 or 2, got %d", c.Secrets.Vault.KVVersion)
+	}
+
+	if c.DryRun.CopyDir != "" {
+		for _, dir := range c.FileWatch.Directories() {
+			if filepath.Clean(dir) == filepath.Clean(c.DryRun.CopyDir) {
+				v.add("dry_run.copy_dir", "must not be a watched directory, copies would be processed again")
+			}
+		}
+	}
+
+	if c.Shutdown.GracePeriod < 0 {
+		v.add("shutdown.grace_period", "must not be negative")
+	}
+
+	if len(v.errs) > 0 {
+		return &ValidationError{Errors: v.errs}
+	}
+	return nil
+}
+
+// validateMagento checks the Magento connection settings and routes
+func (c *Config) validateMagento(v *validator) {
+	m := &c.Magento
+	v.required("magento.base_url", m.BaseURL)
+	if m.BaseURL != "" {
+		v.url("magento.base_url", m.BaseURL)
+	}
+	v.required("magento.token", m.Token)
+
+	if m.Timeout <= 0 {
+		v.add("magento.timeout", "must be positive")
+	}
+	if m.MaxRetries < 1 {
+		v.add("magento.max_retries", "must be at least 1, got %d", m.MaxRetries)
+	}
+	if m.RetryBackoff < 0 {
+		v.add("magento.retry_backoff", "must not be negative")
+	}
+	if m.Cache.BatchSize < 1 {
+		v.add("magento.cache.batch_size", "must be at least 1, got %d", m.Cache.BatchSize)
+	}
+
+	if m.Bulk.Enabled {
+		if m.Bulk.BatchSize < 1 {
+			v.add("magento.bulk.batch_size", "must be at least 1, got %d", m.Bulk.BatchSize)
+		}
+		if m.Bulk.PollInterval <= 0 {
+			v.add("magento.bulk.poll_interval", "must be positive")
+		}
+	}
+
+	if m.Lookup.Backend != "" {
+		v.oneOf("magento.lookup.backend", strings.ToLower(m.Lookup.Backend), "rest", "graphql")
+	}
+	if m.Lookup.GraphQLURL != "" {
+		v.url("magento.lookup.graphql_url", m.Lookup.GraphQLURL)
+	}
+
+	for i, route := range m.Routes {
+		field := fmt.Sprintf("magento.routes[%d]", i)
+		if route.OrderPrefix == "" && route.SourceDir == "" {
+			v.add(field, "needs an order_prefix or a source_dir")
+		}
+		if route.SourceDir != "" && !c.FileWatch.watches(route.SourceDir) {
+			name := route.Name
+			if name == "" {
+				name = field
+			}
+			v.add(field+".source_dir", "route %s never matches: %s is not watched, add it to file_watch.additional_directories", name, route.SourceDir)
+		}
+		if route.BaseURL != "" {
+			v.url(field+".base_url", route.BaseURL)
+		}
+		if route.GraphQLURL != "" {
+			v.url(field+".graphql_url", route.GraphQLURL)
+		}
+	}
+}
+
+// watches reports whether files are picked up from dir
+func (f *FileWatchConfig) watches(dir string) bool {
+	for _, watched := range f.Directories() {
+		if filepath.Clean(watched) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateFileWatch checks the directories and the worker settings
+func (c *Config) validateFileWatch(v *validator) {
+	f := &c.FileWatch
+	v.required("file_watch.directory", f.Directory)
+	v.required("file_watch.processed_dir", f.ProcessedDir)
+	v.required("file_watch.failed_dir", f.FailedDir)
+	for i, dir := range f.AdditionalDirectories {
+		v.required(fmt.Sprintf("file_watch.additional_directories[%d]", i), dir)
+	}
+
+	if _, err := regexp.Compile(f.FilePattern); err != nil {
+		v.add("file_watch.file_pattern", "is not a valid regular expression: %v", err)
+	}
+	if f.MaxConcurrency < 1 {
+		v.add("file_watch.max_concurrency", "must be at least 1, got %d", f.MaxConcurrency)
+	}
+	if f.PollInterval <= 0 {
+		v.add("file_watch.poll_interval", "must be positive")
+	}
+}
+
+// validateTracking checks how rows are applied
+func (c *Config) validateTracking(v *validator) {
+	t := &c.Tracking
+	if t.ShipmentStrategy != "" {
+		v.oneOf("tracking.shipment_strategy", t.ShipmentStrategy, "first", "latest", "all", "untracked-only")
+	}
+	v.oneOf("tracking.tracking_number_check", t.TrackingNumberCheck, "reject", "warn", "off")
+
+	if t.Comment.Enabled {
+		if _, err := template.New("comment").Parse(t.Comment.Template); err != nil {
+			v.add("tracking.comment.template", "is not a valid template: %v", err)
+		}
+	}
+
+	if t.Carriers.Validate {
+		v.required("tracking.carriers.endpoint", t.Carriers.Endpoint)
+	}
+
+	for i, alias := range t.CarrierAliases {
+		field := fmt.Sprintf("tracking.carrier_aliases[%d]", i)
+		v.required(field+".code", alias.Code)
+	}
+}
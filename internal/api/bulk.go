@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// bulkURL returns the asynchronous bulk endpoint base for a REST base URL,
+// turning https://example.com/rest/V1 into https://example.com/rest/async/bulk/V1
+func bulkURL(baseURL string) string {
+	return strings.TrimSuffix(baseURL, "/V1") + "/async/bulk/V1"
+}
+
+// AddTracksAsync submits tracks to Magento's message queue in a single bulk
+// request. Each track must have its ParentID set to the shipment ID. The
+// operations of the returned bulk are numbered by their index in tracks.
+func (c *MagentoClient) AddTracksAsync(ctx context.Context, tracks []model.MagentoTrack) (*model.MagentoBulkResponse, error) {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":    "AddTracksAsync",
+		"track_count": len(tracks),
+	})
+
+	log.Info("Submitting tracks to bulk queue")
+
+	requestBody := make([]map[string]interface{}, len(tracks))
+	for i := range tracks {
+		requestBody[i] = map[string]interface{}{
+			"entity": &tracks[i],
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/shipment/track", bulkURL(c.baseURL))
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal tracking data")
+		return nil, fmt.Errorf("failed to marshal tracking data: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var response model.MagentoBulkResponse
+	if err := c.send(req, &response); err != nil {
+		log.WithError(err).Error("Failed to submit tracks")
+		return nil, fmt.Errorf("failed to submit tracks: %w", err)
+	}
+
+	// Tracks are added out of band, so cached shipments are stale from now on
+	for i := range tracks {
+		c.cache.invalidateOrder(tracks[i].OrderID)
+	}
+
+	log.WithField("bulk_uuid", response.BulkUUID).Info("Successfully submitted tracks")
+	return &response, nil
+}
+
+// GetBulkStatus retrieves the per-operation status of a bulk request
+func (c *MagentoClient) GetBulkStatus(ctx context.Context, bulkUUID string) (*model.MagentoBulkStatus, error) {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":  "GetBulkStatus",
+		"bulk_uuid": bulkUUID,
+	})
+
+	log.Debug("Retrieving bulk status")
+
+	endpoint := fmt.Sprintf("%s/bulk/%s/detailed-status", c.baseURL, url.PathEscape(bulkUUID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var response model.MagentoBulkStatus
+	if err := c.doRequest(req, &response); err != nil {
+		log.WithError(err).Error("Failed to get bulk status")
+		return nil, fmt.Errorf("failed to get bulk status: %w", err)
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"tracking-updater/internal/model"
+)
+
+// lookupCache is an in-process TTL cache of order and shipment lookups. A nil
+// cache is valid and caches nothing.
+type lookupCache struct {
+	ttl   time.Duration
+	mutex sync.Mutex
+
+	orders    map[string]orderEntry // By increment ID
+	shipments map[int]shipmentEntry // By order ID
+
+	// Reverse indexes used to invalidate entries after mutating calls
+	orderIncrementIDs map[int]string // Order ID to increment ID
+	trackOrders       map[int]int    // Track ID to order ID
+}
+
+// orderEntry is a cached order lookup; a nil order records that the order
+// does not exist
+type orderEntry struct {
+	order   *model.MagentoOrder
+	expires time.Time
+}
+
+// shipmentEntry is a cached shipment lookup
+type shipmentEntry struct {
+	shipments []model.MagentoShipment
+	expires   time.Time
+}
+
+// newLookupCache creates a cache, or returns nil when the TTL disables caching
+func newLookupCache(ttl time.Duration) *lookupCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &lookupCache{
+		ttl:               ttl,
+		orders:            make(map[string]orderEntry),
+		shipments:         make(map[int]shipmentEntry),
+		orderIncrementIDs: make(map[int]string),
+		trackOrders:       make(map[int]int),
+	}
+}
+
+// order returns a cached order. ok is false on a cache miss, including
+// orders cached without their items when needItems is set; order is nil when
+// the order is cached as not existing.
+func (c *lookupCache) order(incrementID string, needItems bool) (order *model.MagentoOrder, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.orders[incrementID]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	if entry.order == nil {
+		return nil, true
+	}
+	// Every order has items, so none were fetched with a projection without them
+	if needItems && len(entry.order.Items) == 0 {
+		return nil, false
+	}
+	o := *entry.order
+	return &o, true
+}
+
+// putOrder caches an order
+func (c *lookupCache) putOrder(order *model.MagentoOrder) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	o := *order
+	c.orders[order.IncrementID] = orderEntry{order: &o, expires: time.Now().Add(c.ttl)}
+	c.orderIncrementIDs[order.EntityID] = order.IncrementID
+}
+
+// putMissingOrder records that an order does not exist
+func (c *lookupCache) putMissingOrder(incrementID string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.orders[incrementID] = orderEntry{expires: time.Now().Add(c.ttl)}
+}
+
+// shipmentsFor returns the cached shipments of an order; ok is false on a cache miss
+func (c *lookupCache) shipmentsFor(orderID int) (shipments []model.MagentoShipment, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.shipments[orderID]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return append([]model.MagentoShipment(nil), entry.shipments...), true
+}
+
+// putShipments caches the shipments of an order
+func (c *lookupCache) putShipments(orderID int, shipments []model.MagentoShipment) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.shipments[orderID] = shipmentEntry{
+		shipments: append([]model.MagentoShipment(nil), shipments...),
+		expires:   time.Now().Add(c.ttl),
+	}
+	for _, shipment := range shipments {
+		for _, track := range shipment.Tracks {
+			c.trackOrders[track.EntityID] = orderID
+		}
+	}
+}
+
+// invalidateOrder drops the cached order and shipments of an order
+func (c *lookupCache) invalidateOrder(orderID int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if incrementID, ok := c.orderIncrementIDs[orderID]; ok {
+		delete(c.orders, incrementID)
+	}
+	delete(c.shipments, orderID)
+}
+
+// invalidateTrack drops the cached shipments of the track's order
+func (c *lookupCache) invalidateTrack(trackID int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	orderID, ok := c.trackOrders[trackID]
+	c.mutex.Unlock()
+
+	if ok {
+		c.invalidateOrder(orderID)
+	}
+}
+
+// prune drops expired entries
+func (c *lookupCache) prune() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for incrementID, entry := range c.orders {
+		if now.After(entry.expires) {
+			delete(c.orders, incrementID)
+		}
+	}
+	for orderID, entry := range c.shipments {
+		if now.After(entry.expires) {
+			delete(c.shipments, orderID)
+		}
+	}
+
+	// Drop reverse index entries of orders no longer cached
+	for orderID, incrementID := range c.orderIncrementIDs {
+		if _, ok := c.orders[incrementID]; !ok {
+			if _, ok := c.shipments[orderID]; !ok {
+				delete(c.orderIncrementIDs, orderID)
+			}
+		}
+	}
+	for trackID, orderID := range c.trackOrders {
+		if _, ok := c.shipments[orderID]; !ok {
+			delete(c.trackOrders, trackID)
+		}
+	}
+}
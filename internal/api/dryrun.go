@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Recorder collects the requests changing Magento data that a dry run
+// would have sent
+type Recorder struct {
+	mutex    sync.Mutex
+	requests []model.WouldPost
+}
+
+// recorderKey is the context key of the Recorder
+type recorderKey struct{}
+
+// WithRecorder returns a context in which the requests changing Magento data
+// that a client in dry-run mode refuses are recorded by r
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// Requests returns the recorded requests, in the order they were made
+func (r *Recorder) Requests() []model.WouldPost {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]model.WouldPost(nil), r.requests...)
+}
+
+// record adds a request to the recorder
+func (r *Recorder) record(request model.WouldPost) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, request)
+}
+
+// SetDryRun turns dry-run mode on or off. In dry-run mode the client never
+// sends requests changing Magento data; lookups are still sent.
+func (c *MagentoClient) SetDryRun(enabled bool) {
+	c.dryRun.Store(enabled)
+}
+
+// send performs a request changing Magento data. In dry-run mode the request
+// is not sent but recorded by the Recorder of the request context, and
+// refused with a warning when there is none, so a dry run can never change
+// Magento.
+func (c *MagentoClient) send(req *http.Request, v interface{}) error {
+	if !c.dryRun.Load() {
+		return c.doRequest(req, v)
+	}
+	return c.record(req, v)
+}
+
+// sendOnce performs a request creating Magento data like send, but without
+// retrying it, as a retry after a timeout could create the data twice
+func (c *MagentoClient) sendOnce(req *http.Request, v interface{}) error {
+	if !c.dryRun.Load() {
+		return c.request(req, v, 1)
+	}
+	return c.record(req, v)
+}
+
+// record records a request in dry-run mode instead of sending it
+func (c *MagentoClient) record(req *http.Request, v interface{}) error {
+	log := c.logger.WithContext(req.Context()).WithFields(logrus.Fields{
+		"method": req.Method,
+		"path":   req.URL.Path,
+	})
+
+	recorder, ok := req.Context().Value(recorderKey{}).(*Recorder)
+	if !ok {
+		log.Warn("Dry run, refused request without a recorder")
+		dryRunResponse(v)
+		return nil
+	}
+
+	request := model.WouldPost{Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		if len(body) > 0 {
+			request.Body = json.RawMessage(body)
+		}
+	}
+	recorder.record(request)
+
+	log.Info("Dry run, recorded request instead of sending it")
+	dryRunResponse(v)
+	return nil
+}
+
+// dryRunResponse fills in the response of a recorded request as if Magento
+// accepted it. New shipments get ID 0.
+func dryRunResponse(v interface{}) {
+	switch v := v.(type) {
+	case *bool:
+		*v = true
+	case *json.RawMessage:
+		*v = json.RawMessage("0")
+	}
+}
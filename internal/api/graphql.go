@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GraphQLLookup resolves orders and shipments through Magento GraphQL. It
+// shares the HTTP client, token, retries and cache of a MagentoClient, so
+// results are cached and invalidated exactly like REST lookups.
+//
+// Core Magento has no admin-scoped order and shipment queries, so both
+// queries come from the configuration, for the module providing them. The
+// orders query receives the increment IDs as $increment_ids and returns
+// data.orders.items; the shipments query receives the order entity IDs as
+// $order_ids and returns data.shipments.items. Items use the REST field
+// names, aliasing fields if needed. Lookups without a configured query use
+// the REST API.
+type GraphQLLookup struct {
+	client         *MagentoClient
+	endpoint       string
+	storeCode      string
+	ordersQuery    string
+	shipmentsQuery string
+	logger         *logrus.Logger
+}
+
+// graphQLResponse is the envelope of a GraphQL response
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// NewGraphQLLookup creates a GraphQL lookup using the client's connection settings
+func NewGraphQLLookup(cfg *config.MagentoConfig, client *MagentoClient, logger *logrus.Logger) *GraphQLLookup {
+	endpoint := cfg.Lookup.GraphQLURL
+	if endpoint == "" {
+		endpoint = graphQLURL(cfg.BaseURL)
+	}
+
+	logger.WithField("endpoint", endpoint).Info("Using GraphQL order lookup")
+	if cfg.Lookup.OrdersQuery == "" || cfg.Lookup.ShipmentsQuery == "" {
+		logger.Warn("GraphQL lookup without an orders or shipments query, using REST for those lookups")
+	}
+
+	return &GraphQLLookup{
+		client:         client,
+		endpoint:       endpoint,
+		storeCode:      cfg.StoreCode,
+		ordersQuery:    cfg.Lookup.OrdersQuery,
+		shipmentsQuery: cfg.Lookup.ShipmentsQuery,
+		logger:         logger,
+	}
+}
+
+// graphQLURL derives the GraphQL endpoint from a REST base URL, turning
+// https://example.com/rest/V1 into https://example.com/graphql
+func graphQLURL(baseURL string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if i := strings.LastIndex(baseURL, "/rest"); i != -1 {
+		return baseURL[:i] + "/graphql"
+	}
+	return baseURL + "/graphql"
+}
+
+// GetOrderByIncrementID retrieves order details by increment ID (order number)
+func (g *GraphQLLookup) GetOrderByIncrementID(ctx context.Context, incrementID string) (*model.MagentoOrder, error) {
+	log := g.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":     "GraphQLLookup.GetOrderByIncrementID",
+		"increment_id": incrementID,
+	})
+
+	if order, ok := g.client.cache.order(incrementID, wantsOrderItems(ctx)); ok {
+		if order == nil {
+			log.Debug("Order not found (cached)")
+			return nil, fmt.Errorf("order with increment_id %s not found", incrementID)
+		}
+		log.WithField("order_id", order.EntityID).Debug("Order found in cache")
+		return order, nil
+	}
+
+	log.Info("Retrieving order details")
+
+	orders, err := g.searchOrders(ctx, []string{incrementID})
+	if err != nil {
+		log.WithError(err).Error("Failed to get order")
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	for i := range orders {
+		if orders[i].IncrementID == incrementID {
+			log.WithField("order_id", orders[i].EntityID).Info("Order found")
+			g.client.cache.putOrder(&orders[i])
+			return &orders[i], nil
+		}
+	}
+
+	log.Warn("Order not found")
+	return nil, fmt.Errorf("order with increment_id %s not found", incrementID)
+}
+
+// GetShipmentsByOrderID retrieves shipments for a specific order
+func (g *GraphQLLookup) GetShipmentsByOrderID(ctx context.Context, orderID int) ([]model.MagentoShipment, error) {
+	log := g.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function": "GraphQLLookup.GetShipmentsByOrderID",
+		"order_id": orderID,
+	})
+
+	if shipments, ok := g.client.cache.shipmentsFor(orderID); ok {
+		log.WithField("shipment_count", len(shipments)).Debug("Shipments found in cache")
+		if len(shipments) == 0 {
+			return nil, nil
+		}
+		return shipments, nil
+	}
+
+	log.Info("Retrieving shipments for order")
+
+	shipments, err := g.searchShipments(ctx, []string{strconv.Itoa(orderID)})
+	if err != nil {
+		log.WithError(err).Error("Failed to get shipments")
+		return nil, fmt.Errorf("failed to get shipments: %w", err)
+	}
+
+	g.client.cache.putShipments(orderID, shipments)
+
+	if len(shipments) == 0 {
+		log.Warn("No shipments found")
+		return nil, nil
+	}
+
+	log.WithField("shipment_count", len(shipments)).Info("Shipments found")
+	return shipments, nil
+}
+
+// PrefetchOrders batch-resolves orders and their shipments into the cache
+func (g *GraphQLLookup) PrefetchOrders(ctx context.Context, incrementIDs []string) error {
+	return g.client.prefetch(ctx, incrementIDs, g.searchOrders, g.searchShipments)
+}
+
+// searchOrders returns the orders with the given increment IDs
+func (g *GraphQLLookup) searchOrders(ctx context.Context, incrementIDs []string) ([]model.MagentoOrder, error) {
+	if g.ordersQuery == "" {
+		return g.client.searchOrders(ctx, incrementIDs)
+	}
+
+	var data struct {
+		Orders model.MagentoOrderResponse `json:"orders"`
+	}
+	variables := map[string]interface{}{"increment_ids": incrementIDs}
+	if err := g.query(ctx, g.ordersQuery, variables, &data); err != nil {
+		return nil, err
+	}
+	return data.Orders.Items, nil
+}
+
+// searchShipments returns the shipments of the given orders
+func (g *GraphQLLookup) searchShipments(ctx context.Context, orderIDs []string) ([]model.MagentoShipment, error) {
+	if g.shipmentsQuery == "" {
+		return g.client.searchShipments(ctx, orderIDs)
+	}
+
+	ids := make([]int, 0, len(orderIDs))
+	for _, orderID := range orderIDs {
+		id, err := strconv.Atoi(orderID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid order ID %q: %w", orderID, err)
+		}
+		ids = append(ids, id)
+	}
+
+	var data struct {
+		Shipments model.MagentoShipmentResponse `json:"shipments"`
+	}
+	variables := map[string]interface{}{"order_ids": ids}
+	if err := g.query(ctx, g.shipmentsQuery, variables, &data); err != nil {
+		return nil, err
+	}
+	return data.Shipments.Items, nil
+}
+
+// query runs a GraphQL query and decodes its data into v. Queries are sent
+// as GET requests, which Magento's full page cache and Varnish can serve.
+func (g *GraphQLLookup) query(ctx context.Context, query string, variables map[string]interface{}, v interface{}) error {
+	encodedVariables, err := json.Marshal(variables)
+	if err != nil {
+		return fmt.Errorf("failed to marshal query variables: %w", err)
+	}
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("variables", string(encodedVariables))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", g.client.authorization())
+	if g.storeCode != "" {
+		req.Header.Set("Store", g.storeCode)
+	}
+
+	var response graphQLResponse
+	if err := g.client.doRequest(req, &response); err != nil {
+		return err
+	}
+
+	if len(response.Errors) > 0 {
+		messages := make([]string, len(response.Errors))
+		for i, e := range response.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("graphql error: %s", strings.Join(messages, "; "))
+	}
+
+	return json.Unmarshal(response.Data, v)
+}
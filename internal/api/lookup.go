@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Lookup backends
+const (
+	LookupREST    = "rest"
+	LookupGraphQL = "graphql"
+)
+
+// orderItemsKey is the context key marking lookups that need the order items
+type orderItemsKey struct{}
+
+// WithOrderItems returns a context whose order lookups include the order
+// items, which only item rows and the lookup command use. Other lookups
+// request the orders with the lighter magento.fields.orders projection.
+func WithOrderItems(ctx context.Context) context.Context {
+	return context.WithValue(ctx, orderItemsKey{}, true)
+}
+
+// wantsOrderItems reports whether order lookups in ctx need the order items
+func wantsOrderItems(ctx context.Context) bool {
+	items, _ := ctx.Value(orderItemsKey{}).(bool)
+	return items
+}
+
+// OrderLookup resolves orders and their shipments. Implementations return
+// the same results for the same Magento data, whichever API they use.
+type OrderLookup interface {
+	// GetOrderByIncrementID retrieves an order by its increment ID (order number)
+	GetOrderByIncrementID(ctx context.Context, incrementID string) (*model.MagentoOrder, error)
+
+	// GetShipmentsByOrderID retrieves the shipments of an order; nil when none exist
+	GetShipmentsByOrderID(ctx context.Context, orderID int) ([]model.MagentoShipment, error)
+
+	// PrefetchOrders batch-resolves orders and their shipments into the cache
+	PrefetchOrders(ctx context.Context, incrementIDs []string) error
+}
+
+// NewOrderLookup creates the lookup backend selected in the configuration.
+// Lookups share the client's cache, so its mutating calls invalidate them.
+func NewOrderLookup(cfg *config.MagentoConfig, client *MagentoClient, logger *logrus.Logger) OrderLookup {
+	switch strings.ToLower(cfg.Lookup.Backend) {
+	case LookupGraphQL:
+		return NewGraphQLLookup(cfg, client, logger)
+	case LookupREST, "":
+		return client
+	default:
+		logger.WithField("backend", cfg.Lookup.Backend).Warn("Unknown lookup backend, using REST")
+		return client
+	}
+}
@@ -2,14 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"tracking-updater/config"
+	"tracking-updater/internal/metrics"
 	"tracking-updater/internal/model"
 
 	"github.com/sirupsen/logrus"
@@ -17,35 +23,109 @@ import (
 
 // MagentoClient handles communication with the Magento 2 API
 type MagentoClient struct {
-	baseURL    string
+	baseURL   string
+	settings  atomic.Pointer[clientSettings]
+	dryRun    atomic.Bool // Changes are refused, see send
+	logger    *logrus.Logger
+	cache     *lookupCache
+	batchSize int
+
+	// Field projections applied to order and shipment searches
+	orderFields     string
+	orderItemFields string // For lookups that need the order items
+	shipmentFields  string
+}
+
+// clientSettings holds the client settings a configuration reload may change
+type clientSettings struct {
 	token      string
 	httpClient *http.Client
 	maxRetries int
 	backoff    time.Duration
-	logger     *logrus.Logger
 }
 
 // NewMagentoClient creates a new Magento API client
 func NewMagentoClient(cfg *config.MagentoConfig, logger *logrus.Logger) *MagentoClient {
-	return &MagentoClient{
-		baseURL: cfg.BaseURL,
-		token:   cfg.Token,
+	c := &MagentoClient{
+		baseURL:   storeURL(cfg.BaseURL, cfg.StoreCode),
+		logger:    logger,
+		cache:     newLookupCache(cfg.Cache.TTL),
+		batchSize: cfg.Cache.BatchSize,
+
+		orderFields:     cfg.Fields.Orders,
+		orderItemFields: cfg.Fields.OrderItems,
+		shipmentFields:  cfg.Fields.Shipments,
+	}
+	c.Reconfigure(cfg)
+	return c
+}
+
+// Reconfigure applies the token, timeout and retry settings of a reloaded
+// configuration. Requests already running keep their settings.
+func (c *MagentoClient) Reconfigure(cfg *config.MagentoConfig) {
+	c.settings.Store(&clientSettings{
+		token: cfg.Token,
 		httpClient: &http.Client{
 			Timeout: cfg.Timeout,
 		},
 		maxRetries: cfg.MaxRetries,
 		backoff:    cfg.RetryBackoff,
-		logger:     logger,
+	})
+}
+
+// authorization returns the Authorization header value for requests
+func (c *MagentoClient) authorization() string {
+	return "Bearer " + c.settings.Load().token
+}
+
+// storeURL scopes a REST base URL to a store view, turning
+// https://example.com/rest/V1 into https://example.com/rest/{store_code}/V1
+func storeURL(baseURL, storeCode string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if storeCode == "" {
+		return baseURL
 	}
+
+	store := url.PathEscape(storeCode)
+	if strings.HasSuffix(baseURL, "/V1") {
+		return strings.TrimSuffix(baseURL, "/V1") + "/" + store + "/V1"
+	}
+	return baseURL + "/" + store + "/V1"
+}
+
+// addFields limits a search response to the given field projection, e.g.
+// items[entity_id,increment_id],total_count. An empty projection returns
+// the full documents.
+func addFields(params url.Values, fields string) {
+	if fields != "" {
+		params.Set("fields", fields)
+	}
+}
+
+// orderProjection returns the field projection of order searches in ctx
+func (c *MagentoClient) orderProjection(ctx context.Context) string {
+	if wantsOrderItems(ctx) {
+		return c.orderItemFields
+	}
+	return c.orderFields
 }
 
 // GetOrderByIncrementID retrieves order details by increment ID (order number)
-func (c *MagentoClient) GetOrderByIncrementID(incrementID string) (*model.MagentoOrder, error) {
-	log := c.logger.WithFields(logrus.Fields{
+func (c *MagentoClient) GetOrderByIncrementID(ctx context.Context, incrementID string) (*model.MagentoOrder, error) {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
 		"function":     "GetOrderByIncrementID",
 		"increment_id": incrementID,
 	})
 
+	if order, ok := c.cache.order(incrementID, wantsOrderItems(ctx)); ok {
+		if order == nil {
+			log.Debug("Order not found (cached)")
+			return nil, fmt.Errorf("order with increment_id %s not found", incrementID)
+		}
+		log.WithField("order_id", order.EntityID).Debug("Order found in cache")
+		return order, nil
+	}
+
 	log.Info("Retrieving order details")
 
 	// Build the search criteria to find order by increment_id
@@ -54,16 +134,17 @@ func (c *MagentoClient) GetOrderByIncrementID(incrementID string) (*model.Magent
 	params.Add("searchCriteria[filter_groups][0][filters][0][field]", "increment_id")
 	params.Add("searchCriteria[filter_groups][0][filters][0][value]", incrementID)
 	params.Add("searchCriteria[filter_groups][0][filters][0][condition_type]", "eq")
+	addFields(params, c.orderProjection(ctx))
 
 	fullURL := fmt.Sprintf("%s?%s", endpoint, params.Encode())
 
-	req, err := http.NewRequest(http.MethodGet, fullURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
 		log.WithError(err).Error("Failed to create request")
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", c.authorization())
 	req.Header.Set("Content-Type", "application/json")
 
 	var response model.MagentoOrderResponse
@@ -78,16 +159,25 @@ func (c *MagentoClient) GetOrderByIncrementID(incrementID string) (*model.Magent
 	}
 
 	log.WithField("order_id", response.Items[0].EntityID).Info("Order found")
+	c.cache.putOrder(&response.Items[0])
 	return &response.Items[0], nil
 }
 
 // GetShipmentsByOrderID retrieves shipments for a specific order
-func (c *MagentoClient) GetShipmentsByOrderID(orderID int) ([]model.MagentoShipment, error) {
-	log := c.logger.WithFields(logrus.Fields{
+func (c *MagentoClient) GetShipmentsByOrderID(ctx context.Context, orderID int) ([]model.MagentoShipment, error) {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
 		"function": "GetShipmentsByOrderID",
 		"order_id": orderID,
 	})
 
+	if shipments, ok := c.cache.shipmentsFor(orderID); ok {
+		log.WithField("shipment_count", len(shipments)).Debug("Shipments found in cache")
+		if len(shipments) == 0 {
+			return nil, nil
+		}
+		return shipments, nil
+	}
+
 	log.Info("Retrieving shipments for order")
 
 	endpoint := fmt.Sprintf("%s/shipments", c.baseURL)
@@ -95,16 +185,17 @@ func (c *MagentoClient) GetShipmentsByOrderID(orderID int) ([]model.MagentoShipm
 	params.Add("searchCriteria[filter_groups][0][filters][0][field]", "order_id")
 	params.Add("searchCriteria[filter_groups][0][filters][0][value]", fmt.Sprintf("%d", orderID))
 	params.Add("searchCriteria[filter_groups][0][filters][0][condition_type]", "eq")
+	addFields(params, c.shipmentFields)
 
 	fullURL := fmt.Sprintf("%s?%s", endpoint, params.Encode())
 
-	req, err := http.NewRequest(http.MethodGet, fullURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
 		log.WithError(err).Error("Failed to create request")
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", c.authorization())
 	req.Header.Set("Content-Type", "application/json")
 
 	var response model.MagentoShipmentResponse
@@ -113,6 +204,8 @@ func (c *MagentoClient) GetShipmentsByOrderID(orderID int) ([]model.MagentoShipm
 		return nil, fmt.Errorf("failed to get shipments: %w", err)
 	}
 
+	c.cache.putShipments(orderID, response.Items)
+
 	if response.Total == 0 || len(response.Items) == 0 {
 		log.Warn("No shipments found")
 		return nil, nil
@@ -123,8 +216,8 @@ func (c *MagentoClient) GetShipmentsByOrderID(orderID int) ([]model.MagentoShipm
 }
 
 // AddTrackingToShipment adds tracking information to a shipment
-func (c *MagentoClient) AddTrackingToShipment(shipmentID int, track *model.MagentoTrack) error {
-	log := c.logger.WithFields(logrus.Fields{
+func (c *MagentoClient) AddTrackingToShipment(ctx context.Context, shipmentID int, track *model.MagentoTrack) error {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
 		"function":    "AddTrackingToShipment",
 		"shipment_id": shipmentID,
 		"tracking_no": track.TrackNumber,
@@ -148,41 +241,387 @@ func (c *MagentoClient) AddTrackingToShipment(shipmentID int, track *model.Magen
 		return fmt.Errorf("failed to marshal tracking data: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(body))
 	if err != nil {
 		log.WithError(err).Error("Failed to create request")
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", c.authorization())
 	req.Header.Set("Content-Type", "application/json")
 
 	var response interface{}
-	if err := c.doRequest(req, &response); err != nil {
+	if err := c.send(req, &response); err != nil {
 		log.WithError(err).Error("Failed to add tracking")
 		return fmt.Errorf("failed to add tracking: %w", err)
 	}
+	c.cache.invalidateOrder(track.OrderID)
 
 	log.Info("Successfully added tracking information")
 	return nil
 }
 
+// CreateShipment ships the given order items, attaching the tracks, and
+// returns the ID of the new shipment
+func (c *MagentoClient) CreateShipment(ctx context.Context, orderID int, shipment *model.MagentoShipOrderRequest) (int, error) {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":   "CreateShipment",
+		"order_id":   orderID,
+		"item_count": len(shipment.Items),
+	})
+
+	log.Info("Creating shipment for order")
+
+	endpoint := fmt.Sprintf("%s/order/%d/ship", c.baseURL, orderID)
+
+	body, err := json.Marshal(shipment)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal shipment data")
+		return 0, fmt.Errorf("failed to marshal shipment data: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	// Magento returns the new ID either as a number or as a quoted string.
+	// A retry could ship the items twice, so the request is sent once.
+	var response json.RawMessage
+	if err := c.sendOnce(req, &response); err != nil {
+		log.WithError(err).Error("Failed to create shipment")
+		return 0, fmt.Errorf("failed to create shipment: %w", err)
+	}
+	c.cache.invalidateOrder(orderID)
+
+	shipmentID, err := strconv.Atoi(strings.Trim(string(response), "\""))
+	if err != nil {
+		log.WithError(err).Error("Unexpected shipment ID in response")
+		return 0, fmt.Errorf("unexpected shipment ID %s: %w", response, err)
+	}
+
+	log.WithField("shipment_id", shipmentID).Info("Successfully created shipment")
+	return shipmentID, nil
+}
+
+// DeleteTrack removes a track from its shipment
+func (c *MagentoClient) DeleteTrack(ctx context.Context, trackID int) error {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function": "DeleteTrack",
+		"track_id": trackID,
+	})
+
+	log.Info("Deleting track")
+
+	endpoint := fmt.Sprintf("%s/shipment/track/%d", c.baseURL, trackID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var deleted bool
+	if err := c.send(req, &deleted); err != nil {
+		log.WithError(err).Error("Failed to delete track")
+		return fmt.Errorf("failed to delete track: %w", err)
+	}
+	c.cache.invalidateTrack(trackID)
+
+	if !deleted {
+		log.Warn("Magento declined to delete track")
+		return fmt.Errorf("magento did not delete track %d", trackID)
+	}
+
+	log.Info("Successfully deleted track")
+	return nil
+}
+
+// ReplaceShipmentTracks reconciles the tracks of a shipment with the given
+// list: missing tracks are added, then existing tracks not in the list are
+// deleted. Deleting last means a failed add leaves the old tracks in place
+// rather than a shipment without tracking.
+func (c *MagentoClient) ReplaceShipmentTracks(ctx context.Context, shipmentID int, existing []model.MagentoTrack, tracks []model.MagentoTrack) error {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":    "ReplaceShipmentTracks",
+		"shipment_id": shipmentID,
+	})
+
+	deleted, added := 0, 0
+
+	for i := range tracks {
+		if containsTrack(existing, &tracks[i]) {
+			continue
+		}
+		track := tracks[i]
+		if err := c.AddTrackingToShipment(ctx, shipmentID, &track); err != nil {
+			return err
+		}
+		added++
+	}
+
+	for i := range existing {
+		if containsTrack(tracks, &existing[i]) {
+			continue
+		}
+		if err := c.DeleteTrack(ctx, existing[i].EntityID); err != nil {
+			return err
+		}
+		deleted++
+	}
+
+	log.WithFields(logrus.Fields{
+		"deleted": deleted,
+		"added":   added,
+	}).Info("Replaced shipment tracks")
+	return nil
+}
+
+// containsTrack reports whether a track matching t is in the list
+func containsTrack(tracks []model.MagentoTrack, t *model.MagentoTrack) bool {
+	for i := range tracks {
+		if tracks[i].Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendShipmentEmail asks Magento to send the shipment notification email to the customer
+func (c *MagentoClient) SendShipmentEmail(ctx context.Context, shipmentID int) error {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":    "SendShipmentEmail",
+		"shipment_id": shipmentID,
+	})
+
+	log.Info("Sending shipment email to customer")
+
+	endpoint := fmt.Sprintf("%s/shipment/%d/emails", c.baseURL, shipmentID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var sent bool
+	if err := c.send(req, &sent); err != nil {
+		log.WithError(err).Error("Failed to send shipment email")
+		return fmt.Errorf("failed to send shipment email: %w", err)
+	}
+
+	if !sent {
+		log.Warn("Magento declined to send shipment email")
+		return fmt.Errorf("magento did not send the shipment email for shipment %d", shipmentID)
+	}
+
+	log.Info("Successfully sent shipment email")
+	return nil
+}
+
+// AddShipmentComment adds a comment to a shipment
+func (c *MagentoClient) AddShipmentComment(ctx context.Context, shipmentID int, comment *model.MagentoShipmentComment) error {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":    "AddShipmentComment",
+		"shipment_id": shipmentID,
+	})
+
+	log.Info("Adding comment to shipment")
+
+	// Set the shipment ID
+	comment.ParentID = shipmentID
+
+	requestBody := map[string]interface{}{
+		"entity": comment,
+	}
+
+	endpoint := fmt.Sprintf("%s/shipment/%d/comments", c.baseURL, shipmentID)
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal comment data")
+		return fmt.Errorf("failed to marshal comment data: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var response interface{}
+	if err := c.send(req, &response); err != nil {
+		log.WithError(err).Error("Failed to add shipment comment")
+		return fmt.Errorf("failed to add shipment comment: %w", err)
+	}
+
+	log.Info("Successfully added shipment comment")
+	return nil
+}
+
+// AddOrderComment adds a comment to an order's status history, changing the
+// order status when the comment carries one
+func (c *MagentoClient) AddOrderComment(ctx context.Context, orderID int, comment *model.MagentoOrderStatusHistory) error {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function": "AddOrderComment",
+		"order_id": orderID,
+		"status":   comment.Status,
+	})
+
+	log.Info("Adding comment to order")
+
+	// Set the order ID
+	comment.ParentID = orderID
+
+	requestBody := map[string]interface{}{
+		"statusHistory": comment,
+	}
+
+	endpoint := fmt.Sprintf("%s/orders/%d/comments", c.baseURL, orderID)
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal order comment data")
+		return fmt.Errorf("failed to marshal order comment data: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var added bool
+	if err := c.send(req, &added); err != nil {
+		log.WithError(err).Error("Failed to add order comment")
+		return fmt.Errorf("failed to add order comment: %w", err)
+	}
+	c.cache.invalidateOrder(orderID)
+
+	if !added {
+		log.Warn("Magento declined to add order comment")
+		return fmt.Errorf("magento did not add the comment to order %d", orderID)
+	}
+
+	log.Info("Successfully added order comment")
+	return nil
+}
+
+// GetCarriers retrieves the active shipping carriers from the given endpoint,
+// relative to the base URL. Magento has no core endpoint listing carriers, so
+// this expects one provided by an extension returning code, title and active.
+func (c *MagentoClient) GetCarriers(ctx context.Context, endpoint string) ([]model.MagentoCarrier, error) {
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function": "GetCarriers",
+		"endpoint": endpoint,
+	})
+
+	log.Info("Retrieving carriers")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s", c.baseURL, strings.TrimLeft(endpoint, "/")), nil)
+	if err != nil {
+		log.WithError(err).Error("Failed to create request")
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var carriers []model.MagentoCarrier
+	if err := c.doRequest(req, &carriers); err != nil {
+		log.WithError(err).Error("Failed to get carriers")
+		return nil, fmt.Errorf("failed to get carriers: %w", err)
+	}
+
+	active := carriers[:0]
+	for _, carrier := range carriers {
+		if carrier.Active {
+			active = append(active, carrier)
+		}
+	}
+
+	log.WithField("carrier_count", len(active)).Info("Carriers found")
+	return active, nil
+}
+
+// Ping checks that Magento is reachable and accepts the token with the
+// cheapest authenticated call the token needs permission for anyway: an
+// order search returning only the total count
+func (c *MagentoClient) Ping(ctx context.Context) error {
+	params := url.Values{}
+	params.Add("searchCriteria[pageSize]", "1")
+	params.Add("fields", "total_count")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/orders?%s", c.baseURL, params.Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	var response model.MagentoOrderResponse
+	if err := c.doRequest(req, &response); err != nil {
+		return fmt.Errorf("magento at %s not available: %w", c.baseURL, err)
+	}
+	return nil
+}
+
 // doRequest performs the HTTP request with retry logic
 func (c *MagentoClient) doRequest(req *http.Request, v interface{}) error {
+	return c.request(req, v, c.settings.Load().maxRetries)
+}
+
+// request performs the HTTP request, making up to maxAttempts attempts. The
+// body of each retry is rebuilt from the request's GetBody.
+func (c *MagentoClient) request(req *http.Request, v interface{}, maxAttempts int) error {
 	var resp *http.Response
 	var err error
 	attempts := 0
+	endpoint := endpointLabel(req.URL.Path)
+	settings := c.settings.Load()
 
-	for attempts < c.maxRetries {
+	for attempts < maxAttempts {
 		attempts++
+		if attempts > 1 {
+			metrics.MagentoRetries.WithLabelValues(endpoint, req.Method).Inc()
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return fmt.Errorf("failed to rebuild request body: %w", err)
+				}
+				req.Body = body
+			}
+		}
 
-		resp, err = c.httpClient.Do(req)
+		ctx, span := startAttempt(req, endpoint, attempts)
+		log := c.logger.WithContext(ctx)
+
+		startTime := time.Now()
+		resp, err = settings.httpClient.Do(req)
 		if err != nil {
-			c.logger.WithError(err).WithField("attempt", attempts).
+			finishAttempt(span, endpoint, req.Method, 0, startTime, err)
+			log.WithError(err).WithField("attempt", attempts).
 				Warn("Request failed, retrying...")
 
-			if attempts < c.maxRetries {
-				time.Sleep(c.backoff * time.Duration(attempts))
+			if attempts < maxAttempts {
+				time.Sleep(settings.backoff * time.Duration(attempts))
 				continue
 			}
 			return fmt.Errorf("request failed after %d attempts: %w", attempts, err)
@@ -194,25 +633,36 @@ func (c *MagentoClient) doRequest(req *http.Request, v interface{}) error {
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 			body, _ := io.ReadAll(resp.Body)
 			errMsg := fmt.Sprintf("api error (status: %d): %s", resp.StatusCode, string(body))
+			finishAttempt(span, endpoint, req.Method, resp.StatusCode, startTime, errors.New(errMsg))
 
-			c.logger.WithField("status_code", resp.StatusCode).
+			log.WithField("status_code", resp.StatusCode).
 				WithField("attempt", attempts).
 				WithField("response", string(body)).
 				Warn("API returned error, retrying...")
 
-			if attempts < c.maxRetries {
-				time.Sleep(c.backoff * time.Duration(attempts))
-				// Need to recreate the request body for retries
-				if req.Body != nil {
-					req.Body = io.NopCloser(bytes.NewBuffer(body))
-				}
+			if attempts < maxAttempts {
+				time.Sleep(settings.backoff * time.Duration(attempts))
 				continue
 			}
 			return fmt.Errorf(errMsg)
 		}
 
 		// Successful response
-		return json.NewDecoder(resp.Body).Decode(v)
+		body, err := io.ReadAll(resp.Body)
+		finishAttempt(span, endpoint, req.Method, resp.StatusCode, startTime, err)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+
+		log.WithFields(logrus.Fields{
+			"method":  req.Method,
+			"path":    req.URL.Path,
+			"fields":  req.URL.Query().Get("fields"),
+			"bytes":   len(body),
+			"elapsed": time.Since(startTime),
+		}).Debug("API request completed")
+
+		return json.Unmarshal(body, v)
 	}
 
 	return fmt.Errorf("max retries exceeded")
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"tracking-updater/internal/metrics"
+	"tracking-updater/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endpointLabel turns a request path into a low-cardinality metric label:
+// everything up to the API version is dropped and IDs are replaced, so
+// /rest/default/V1/shipment/42/comments becomes shipment/{id}/comments
+func endpointLabel(path string) string {
+	if strings.HasSuffix(path, "/graphql") {
+		return "graphql"
+	}
+
+	prefix := ""
+	if strings.Contains(path, "/async/bulk/V1/") {
+		prefix = "async/bulk/"
+	}
+	if i := strings.Index(path, "/V1/"); i != -1 {
+		path = path[i+len("/V1/"):]
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if isIDSegment(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return prefix + strings.Join(segments, "/")
+}
+
+// isIDSegment reports whether a path segment is an entity ID or bulk UUID
+func isIDSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for _, r := range segment {
+		if unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// startAttempt starts a client span for one attempt of a request and
+// propagates it to Magento in the request headers
+func startAttempt(req *http.Request, endpoint string, attempt int) (context.Context, trace.Span) {
+	ctx, span := tracing.Tracer().Start(req.Context(), fmt.Sprintf("%s %s", req.Method, endpoint),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.String()),
+			semconv.ServerAddress(req.URL.Hostname()),
+			attribute.String("magento.endpoint", endpoint),
+		))
+	if attempt > 1 {
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt - 1))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return ctx, span
+}
+
+// finishAttempt records the duration and outcome of a request attempt in
+// the metrics and ends its span; status is 0 when no response was received
+func finishAttempt(span trace.Span, endpoint, method string, status int, start time.Time, err error) {
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	}
+	metrics.MagentoRequestDuration.WithLabelValues(endpoint, method, label).Observe(time.Since(start).Seconds())
+	tracing.EndSpan(span, err)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultBatchSize is used when no prefetch batch size is configured
+const defaultBatchSize = 100
+
+// PrefetchOrders resolves the given orders and their shipments with batched
+// condition_type=in searches and stores them in the lookup cache, so the
+// per-row lookups that follow are served from memory. Orders Magento does not
+// return are cached as missing. It does nothing when caching is disabled.
+func (c *MagentoClient) PrefetchOrders(ctx context.Context, incrementIDs []string) error {
+	return c.prefetch(ctx, incrementIDs, c.searchOrders, c.searchShipments)
+}
+
+// prefetch batch-resolves orders and their shipments into the cache using
+// the given search functions; shipments are searched by order entity ID
+func (c *MagentoClient) prefetch(ctx context.Context, incrementIDs []string,
+	searchOrders func(context.Context, []string) ([]model.MagentoOrder, error),
+	searchShipments func(context.Context, []string) ([]model.MagentoShipment, error)) error {
+	if c.cache == nil {
+		return nil
+	}
+	c.cache.prune()
+
+	// Only look up what is not cached yet; values containing the "in"
+	// separator cannot be batched and are left to the per-row lookup
+	seen := make(map[string]bool)
+	var pending []string
+	for _, incrementID := range incrementIDs {
+		if strings.TrimSpace(incrementID) == "" || seen[incrementID] || strings.Contains(incrementID, ",") {
+			continue
+		}
+		seen[incrementID] = true
+		if _, ok := c.cache.order(incrementID, wantsOrderItems(ctx)); !ok {
+			pending = append(pending, incrementID)
+		}
+	}
+
+	batchSize := c.batchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	log := c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"function":    "PrefetchOrders",
+		"order_count": len(pending),
+		"batch_size":  batchSize,
+	})
+	log.Info("Prefetching orders")
+
+	found, requests := 0, 0
+	for start := 0; start < len(pending); start += batchSize {
+		end := start + batchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+
+		orders, err := searchOrders(ctx, batch)
+		requests++
+		if err != nil {
+			return fmt.Errorf("failed to prefetch orders: %w", err)
+		}
+
+		returned := make(map[string]bool)
+		orderIDs := make([]string, 0, len(orders))
+		for i := range orders {
+			c.cache.putOrder(&orders[i])
+			returned[orders[i].IncrementID] = true
+			orderIDs = append(orderIDs, strconv.Itoa(orders[i].EntityID))
+		}
+		for _, incrementID := range batch {
+			if !returned[incrementID] {
+				c.cache.putMissingOrder(incrementID)
+			}
+		}
+		found += len(orders)
+
+		if len(orderIDs) == 0 {
+			continue
+		}
+
+		shipments, err := searchShipments(ctx, orderIDs)
+		requests++
+		if err != nil {
+			return fmt.Errorf("failed to prefetch shipments: %w", err)
+		}
+
+		byOrder := make(map[int][]model.MagentoShipment)
+		for _, shipment := range shipments {
+			byOrder[shipment.OrderID] = append(byOrder[shipment.OrderID], shipment)
+		}
+		for i := range orders {
+			c.cache.putShipments(orders[i].EntityID, byOrder[orders[i].EntityID])
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"found":    found,
+		"missing":  len(pending) - found,
+		"requests": requests,
+	}).Info("Prefetched orders")
+	return nil
+}
+
+// searchOrders returns the orders with the given increment IDs
+func (c *MagentoClient) searchOrders(ctx context.Context, incrementIDs []string) ([]model.MagentoOrder, error) {
+	params := inFilter("increment_id", incrementIDs)
+	params.Add("searchCriteria[pageSize]", strconv.Itoa(len(incrementIDs)))
+	addFields(params, c.orderProjection(ctx))
+
+	var response model.MagentoOrderResponse
+	if err := c.search(ctx, "orders", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Items, nil
+}
+
+// searchShipments returns the shipments of the given orders
+func (c *MagentoClient) searchShipments(ctx context.Context, orderIDs []string) ([]model.MagentoShipment, error) {
+	params := inFilter("order_id", orderIDs)
+	addFields(params, c.shipmentFields)
+
+	var response model.MagentoShipmentResponse
+	if err := c.search(ctx, "shipments", params, &response); err != nil {
+		return nil, err
+	}
+	return response.Items, nil
+}
+
+// inFilter builds search criteria matching any of the values of a field
+func inFilter(field string, values []string) url.Values {
+	params := url.Values{}
+	params.Add("searchCriteria[filter_groups][0][filters][0][field]", field)
+	params.Add("searchCriteria[filter_groups][0][filters][0][value]", strings.Join(values, ","))
+	params.Add("searchCriteria[filter_groups][0][filters][0][condition_type]", "in")
+	return params
+}
+
+// search performs a GET search request against a collection endpoint
+func (c *MagentoClient) search(ctx context.Context, collection string, params url.Values, v interface{}) error {
+	fullURL := fmt.Sprintf("%s/%s?%s", c.baseURL, collection, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", c.authorization())
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.doRequest(req, v)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"tracking-updater/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Router selects the Magento client responsible for an order, based on the
+// order number prefix or the directory the CSV file was picked up from
+type Router struct {
+	defaultRoute route
+	routes       []route
+	logger       *logrus.Logger
+}
+
+// route is a configured routing rule together with its client and lookup
+type route struct {
+	index       int // Position in MagentoConfig.Routes
+	orderPrefix string
+	sourceDir   string
+	client      *MagentoClient
+	lookup      OrderLookup
+}
+
+// NewRouter creates a router with one client per configured route
+func NewRouter(cfg *config.MagentoConfig, logger *logrus.Logger) *Router {
+	defaultClient := NewMagentoClient(cfg, logger)
+	router := &Router{
+		defaultRoute: route{
+			client: defaultClient,
+			lookup: NewOrderLookup(cfg, defaultClient, logger),
+		},
+		logger: logger,
+	}
+
+	for i, r := range cfg.Routes {
+		name := r.Name
+		if name == "" {
+			name = r.OrderPrefix + r.SourceDir
+		}
+
+		if r.OrderPrefix == "" && r.SourceDir == "" {
+			logger.WithField("route_index", i).Warn("Magento route has neither order_prefix nor source_dir, ignoring")
+			continue
+		}
+
+		routeCfg := cfg.ForRoute(r)
+		sourceDir := ""
+		if r.SourceDir != "" {
+			sourceDir = filepath.Clean(r.SourceDir)
+		}
+
+		client := NewMagentoClient(&routeCfg, logger)
+		router.routes = append(router.routes, route{
+			index:       i,
+			orderPrefix: r.OrderPrefix,
+			sourceDir:   sourceDir,
+			client:      client,
+			lookup:      NewOrderLookup(&routeCfg, client, logger),
+		})
+
+		logger.WithFields(logrus.Fields{
+			"route":        name,
+			"order_prefix": r.OrderPrefix,
+			"source_dir":   sourceDir,
+			"store_code":   routeCfg.StoreCode,
+		}).Info("Registered Magento route")
+	}
+
+	return router
+}
+
+// ClientFor returns the client for an order read from the given file
+func (r *Router) ClientFor(filePath, orderNumber string) *MagentoClient {
+	return r.match(filePath, orderNumber).client
+}
+
+// LookupFor returns the order lookup for an order read from the given file
+func (r *Router) LookupFor(filePath, orderNumber string) OrderLookup {
+	return r.match(filePath, orderNumber).lookup
+}
+
+// match returns the route for an order read from the given file. Routes are
+// evaluated in configuration order and every criterion a route sets must
+// match; the default route is used when no route matches.
+func (r *Router) match(filePath, orderNumber string) *route {
+	dir := filepath.Clean(filepath.Dir(filePath))
+
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if rt.sourceDir != "" && rt.sourceDir != dir {
+			continue
+		}
+		if rt.orderPrefix != "" && !strings.HasPrefix(orderNumber, rt.orderPrefix) {
+			continue
+		}
+		return rt
+	}
+
+	return &r.defaultRoute
+}
+
+// PrefetchOrders batch-resolves the orders of a file, each through the lookup
+// responsible for it
+func (r *Router) PrefetchOrders(ctx context.Context, filePath string, orderNumbers []string) error {
+	byLookup := make(map[OrderLookup][]string)
+	var lookups []OrderLookup
+	for _, orderNumber := range orderNumbers {
+		lookup := r.LookupFor(filePath, orderNumber)
+		if _, ok := byLookup[lookup]; !ok {
+			lookups = append(lookups, lookup)
+		}
+		byLookup[lookup] = append(byLookup[lookup], orderNumber)
+	}
+
+	for _, lookup := range lookups {
+		if err := lookup.PrefetchOrders(ctx, byLookup[lookup]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clients returns the distinct clients of all routes, the default one first
+func (r *Router) Clients() []*MagentoClient {
+	clients := []*MagentoClient{r.defaultRoute.client}
+	for _, rt := range r.routes {
+		clients = append(clients, rt.client)
+	}
+	return clients
+}
+
+// SetDryRun turns dry-run mode on or off for the clients of every route
+func (r *Router) SetDryRun(enabled bool) {
+	for _, client := range r.Clients() {
+		client.SetDryRun(enabled)
+	}
+}
+
+// Ping checks that every Magento instance is reachable and accepts its token
+func (r *Router) Ping(ctx context.Context) error {
+	var errs []error
+	for _, client := range r.Clients() {
+		if err := client.Ping(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reconfigure applies the token, timeout and retry settings of a reloaded
+// configuration to every client. The routes themselves must be unchanged.
+func (r *Router) Reconfigure(cfg *config.MagentoConfig) {
+	r.defaultRoute.client.Reconfigure(cfg)
+	for _, rt := range r.routes {
+		routeCfg := cfg.ForRoute(cfg.Routes[rt.index])
+		rt.client.Reconfigure(&routeCfg)
+	}
+}
@@ -1,9 +1,12 @@
 package file
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync/atomic"
 	"time"
 
 	"tracking-updater/config"
@@ -22,6 +25,7 @@ type Watcher struct {
 	stopChan    chan struct{}
 	filePattern *regexp.Regexp
 	isRunning   bool
+	looping     atomic.Bool // Whether watchLoop is running
 }
 
 // NewWatcher creates a new file watcher
@@ -55,21 +59,25 @@ func (w *Watcher) Start() error {
 		return nil
 	}
 
-	w.logger.WithField("directory", w.config.Directory).Info("Starting file watcher")
+	for _, dir := range w.config.Directories() {
+		w.logger.WithField("directory", dir).Info("Starting file watcher")
 
-	// Ensure the directory exists
-	if err := os.MkdirAll(w.config.Directory, 0755); err != nil {
-		return err
-	}
+		// Ensure the directory exists
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
 
-	// Add the directory to the watcher
-	if err := w.watcher.Add(w.config.Directory); err != nil {
-		return err
+		// Add the directory to the watcher
+		if err := w.watcher.Add(dir); err != nil {
+			return err
+		}
 	}
 
 	w.isRunning = true
 
-	// Start the file watcher goroutine
+	// Start the file watcher goroutine. It counts as running from here on, so
+	// a liveness check right after Start does not report it dead.
+	w.looping.Store(true)
 	go w.watchLoop()
 
 	// Process any existing files on startup
@@ -90,8 +98,19 @@ func (w *Watcher) Stop() {
 	w.isRunning = false
 }
 
+// Alive reports an error when the watch loop is not running, as no new
+// files would be picked up
+func (w *Watcher) Alive(ctx context.Context) error {
+	if !w.looping.Load() {
+		return errors.New("file watcher is not running")
+	}
+	return nil
+}
+
 // watchLoop monitors the directory for new files
 func (w *Watcher) watchLoop() {
+	defer w.looping.Store(false)
+
 	for {
 		select {
 		case <-w.stopChan:
@@ -140,16 +159,18 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 func (w *Watcher) processExistingFiles() {
 	w.logger.Info("Processing existing files")
 
-	files, err := filepath.Glob(filepath.Join(w.config.Directory, "*.csv"))
-	if err != nil {
-		w.logger.WithError(err).Error("Failed to list existing files")
-		return
-	}
+	for _, dir := range w.config.Directories() {
+		files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+		if err != nil {
+			w.logger.WithError(err).WithField("directory", dir).Error("Failed to list existing files")
+			continue
+		}
 
-	for _, file := range files {
-		if w.isTargetFile(file) && w.isFileReady(file) {
-			w.logger.WithField("file", file).Info("Processing existing file")
-			w.processor.ProcessFile(file)
+		for _, file := range files {
+			if w.isTargetFile(file) && w.isFileReady(file) {
+				w.logger.WithField("file", file).Info("Processing existing file")
+				w.processor.ProcessFile(file)
+			}
 		}
 	}
 }
@@ -0,0 +1,34 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// DirWritable checks that a file can be created in the directory
+func DirWritable(dir string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return fmt.Errorf("directory %s is not writable: %w", dir, err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// DiskSpace checks that the file system holding the directory has at least
+// minFree bytes available
+func DiskSpace(dir string, minFree uint64) Check {
+	return func(ctx context.Context) error {
+		free, err := freeBytes(dir)
+		if err != nil {
+			return fmt.Errorf("failed to get free disk space of %s: %w", dir, err)
+		}
+		if free < minFree {
+			return fmt.Errorf("only %d MB free on %s, need %d MB", free>>20, dir, minFree>>20)
+		}
+		return nil
+	}
+}
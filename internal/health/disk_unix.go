@@ -0,0 +1,15 @@
+//go:build unix
+
+package health
+
+import "golang.org/x/sys/unix"
+
+// freeBytes returns the bytes available to unprivileged users on the file
+// system holding path
+func freeBytes(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports the health of one dependency or component; a nil error
+// means healthy
+type Check func(ctx context.Context) error
+
+// Check statuses
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// namedCheck is a registered check
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the liveness and readiness checks of the service
+type Registry struct {
+	mutex     sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+	timeout   time.Duration
+}
+
+// Report is the JSON body of the health endpoints
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// NewRegistry creates an empty registry; each check is given at most timeout
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout}
+}
+
+// AddLiveness registers a check that fails when the process must be restarted
+func (r *Registry) AddLiveness(name string, check Check) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.liveness = append(r.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadiness registers a check that fails while the service cannot do its
+// work, such as when Magento is unreachable
+func (r *Registry) AddReadiness(name string, check Check) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.readiness = append(r.readiness, namedCheck{name: name, check: check})
+}
+
+// Liveness runs the liveness checks
+func (r *Registry) Liveness(ctx context.Context) Report {
+	r.mutex.RLock()
+	checks := append([]namedCheck(nil), r.liveness...)
+	r.mutex.RUnlock()
+	return r.run(ctx, checks)
+}
+
+// Readiness runs the liveness and readiness checks, as a service that is not
+// alive is not ready either
+func (r *Registry) Readiness(ctx context.Context) Report {
+	r.mutex.RLock()
+	checks := append(append([]namedCheck(nil), r.liveness...), r.readiness...)
+	r.mutex.RUnlock()
+	return r.run(ctx, checks)
+}
+
+// run runs checks concurrently and collects their results
+func (r *Registry) run(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			result := r.runCheck(ctx, c.check)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[c.name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// runCheck runs a single check, failing it when it exceeds the timeout
+func (r *Registry) runCheck(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler serves the liveness report, with status 503 when a check fails
+func (r *Registry) LivenessHandler() http.Handler {
+	return reportHandler(r.Liveness)
+}
+
+// ReadinessHandler serves the readiness report, with status 503 when a check fails
+func (r *Registry) ReadinessHandler() http.Handler {
+	return reportHandler(r.Readiness)
+}
+
+// reportHandler serves a report as JSON
+func reportHandler(run func(ctx context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := run(req.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
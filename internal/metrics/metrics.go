@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes every metric name
+const namespace = "tracking_updater"
+
+// File dispositions
+const (
+	DispositionProcessed = "processed"
+	DispositionFailed    = "failed"
+
+	// Interrupted files stay in place and are resumed on the next start
+	DispositionInterrupted = "interrupted"
+)
+
+var (
+	// FilesProcessed counts processed files by where they were moved
+	FilesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_processed_total",
+		Help:      "Files processed, by disposition (processed, failed or interrupted).",
+	}, []string{"disposition"})
+
+	// RowsProcessed counts CSV rows by outcome
+	RowsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_processed_total",
+		Help:      "CSV rows processed, by outcome.",
+	}, []string{"outcome"})
+
+	// MagentoRequestDuration observes the duration of each Magento request
+	// attempt by endpoint, method and status code
+	MagentoRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "magento_request_duration_seconds",
+		Help:      "Duration of Magento API request attempts, by endpoint, method and status code (error when no response was received).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "method", "status"})
+
+	// MagentoRetries counts retried Magento requests by endpoint and method
+	MagentoRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "magento_request_retries_total",
+		Help:      "Magento API request retries, by endpoint and method.",
+	}, []string{"endpoint", "method"})
+
+	// QueueDepth is the number of files waiting for a worker
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Files queued for processing and not yet picked up by a worker.",
+	})
+
+	// Workers is the number of worker goroutines
+	Workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "Number of file processing workers.",
+	})
+
+	// WorkersBusy is the number of workers processing a file
+	WorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Number of workers currently processing a file.",
+	})
+
+	// WorkerBusySeconds accumulates the time workers spent processing files;
+	// its rate divided by the number of workers is the worker utilization
+	WorkerBusySeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_busy_seconds_total",
+		Help:      "Total time workers spent processing files.",
+	})
+
+	// LastSuccessfulFile is the time the last file was moved to the processed directory
+	LastSuccessfulFile = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_file_timestamp_seconds",
+		Help:      "Unix time at which the last file was processed successfully.",
+	})
+)
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CarrierAlias maps the names partners use for a carrier to its Magento
+// carrier code and canonical title
+type CarrierAlias struct {
+	Code  string
+	Title string
+	Names []string
+}
+
+// CarrierNormalizer maps free-text carrier names such as "Fed Ex" or
+// "United Parcel Service" to Magento carrier codes and titles
+type CarrierNormalizer struct {
+	aliases map[string]CarrierAlias // By carrierKey of each name and code
+}
+
+// NewCarrierNormalizer creates a normalizer for the given aliases. Every
+// alias also matches its own code and title.
+func NewCarrierNormalizer(aliases []CarrierAlias) *CarrierNormalizer {
+	n := &CarrierNormalizer{aliases: make(map[string]CarrierAlias)}
+	for _, alias := range aliases {
+		names := append([]string{alias.Code, alias.Title}, alias.Names...)
+		for _, name := range names {
+			if key := carrierKey(name); key != "" {
+				n.aliases[key] = alias
+			}
+		}
+	}
+	return n
+}
+
+// Enabled reports whether any alias is configured
+func (n *CarrierNormalizer) Enabled() bool {
+	return len(n.aliases) > 0
+}
+
+// Normalize rewrites the carrier code and title of the tracking information
+// to the canonical values of its alias. The carrier column is matched first,
+// then the title. It returns false when neither matches an alias; empty
+// carriers are left alone and reported as mapped.
+func (n *CarrierNormalizer) Normalize(t *TrackingInfo) bool {
+	if strings.TrimSpace(t.CarrierCode) == "" && strings.TrimSpace(t.Title) == "" {
+		return true
+	}
+
+	for _, name := range []string{t.CarrierCode, t.Title} {
+		if alias, ok := n.aliases[carrierKey(name)]; ok {
+			t.CarrierCode = alias.Code
+			if alias.Title != "" {
+				t.Title = alias.Title
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// carrierKey reduces a carrier name to lower-case letters and digits, so
+// "Fed Ex", "FEDEX" and "fed-ex" share a key
+func carrierKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
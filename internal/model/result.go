@@ -0,0 +1,134 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Row outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeSkipped = "skipped"
+	OutcomeFailed  = "failed"
+	OutcomePending = "pending" // Submitted to Magento's bulk queue but not completed yet
+)
+
+// Notification outcomes
+const (
+	NotificationSent   = "sent"
+	NotificationFailed = "failed"
+)
+
+// Comment outcomes
+const (
+	CommentAdded  = "added"
+	CommentFailed = "failed"
+)
+
+// Order status update outcomes
+const (
+	StatusUpdated      = "updated"
+	StatusUpdateFailed = "failed"
+)
+
+// RowResult records what happened to a single CSV row
+type RowResult struct {
+	Line                  int    `json:"line"`
+	BulkUUID              string `json:"bulk_uuid,omitempty"`
+	Action                string `json:"action,omitempty"`
+	OrderNumber           string `json:"order_number,omitempty"`
+	TrackingNumber        string `json:"tracking_number,omitempty"`
+	CarrierCode           string `json:"carrier_code,omitempty"`
+	DetectedCarrier       string `json:"detected_carrier,omitempty"` // Carrier detected from the tracking number format
+	CarrierMismatch       bool   `json:"carrier_mismatch,omitempty"`
+	TrackingNumberWarning string `json:"tracking_number_warning,omitempty"` // Failed carrier checks in "warn" mode
+	ShipmentIDs           []int  `json:"shipment_ids,omitempty"`
+	Outcome               string `json:"outcome"`
+	Error                 string `json:"error,omitempty"`
+	Notification          string `json:"notification,omitempty"`
+	NotificationError     string `json:"notification_error,omitempty"`
+	Comment               string `json:"comment,omitempty"`
+	CommentError          string `json:"comment_error,omitempty"`
+	OrderStatus           string `json:"order_status,omitempty"`
+	StatusUpdate          string `json:"status_update,omitempty"`
+	StatusUpdateError     string `json:"status_update_error,omitempty"`
+
+	// WouldPost lists the requests a dry run recorded instead of sending
+	WouldPost []WouldPost `json:"would_post,omitempty"`
+}
+
+// WouldPost is a request changing Magento data that a dry run recorded
+// instead of sending
+type WouldPost struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// FileResult aggregates the row results of a processed file
+type FileResult struct {
+	File      string        `json:"file"`
+	StartedAt time.Time     `json:"started_at"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Rows      []RowResult   `json:"rows"`
+	DryRun    bool          `json:"dry_run,omitempty"` // Nothing was changed in Magento
+
+	// UnmappedCarriers counts the carrier values no alias matched
+	UnmappedCarriers map[string]int `json:"unmapped_carriers,omitempty"`
+}
+
+// Count returns the number of rows with the given outcome
+func (r *FileResult) Count(outcome string) int {
+	count := 0
+	for _, row := range r.Rows {
+		if row.Outcome == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// NotificationCount returns the number of rows with the given notification outcome
+func (r *FileResult) NotificationCount(outcome string) int {
+	count := 0
+	for _, row := range r.Rows {
+		if row.Notification == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// CommentCount returns the number of rows with the given comment outcome
+func (r *FileResult) CommentCount(outcome string) int {
+	count := 0
+	for _, row := range r.Rows {
+		if row.Comment == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// StatusUpdateCount returns the number of rows with the given order status update outcome
+func (r *FileResult) StatusUpdateCount(outcome string) int {
+	count := 0
+	for _, row := range r.Rows {
+		if row.StatusUpdate == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// CarrierMismatchCount returns the number of rows whose carrier differs from
+// the one detected from their tracking number
+func (r *FileResult) CarrierMismatchCount() int {
+	count := 0
+	for _, row := range r.Rows {
+		if row.CarrierMismatch {
+			count++
+		}
+	}
+	return count
+}
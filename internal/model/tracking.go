@@ -5,37 +5,93 @@ import (
 	"strings"
 )
 
+// Tracking actions
+const (
+	ActionAdd     = "add"
+	ActionReplace = "replace"
+	ActionDelete  = "delete"
+)
+
 // TrackingInfo represents tracking information from a CSV file
 type TrackingInfo struct {
+	Action         string `json:"action"`
 	OrderNumber    string `json:"order_number"`
 	TrackingNumber string `json:"tracking_number"`
 	CarrierCode    string `json:"carrier_code"`
 	Title          string `json:"title"`
+	Notify         *bool  `json:"notify,omitempty"` // Per-row override of tracking.notify_customer
+
+	// Optional shipment selection, see tracking.shipment_strategy
+	ShipmentIncrementID string   `json:"shipment_increment_id,omitempty"`
+	SKUs                []string `json:"skus,omitempty"`
+
+	// Item rows create a new shipment containing this item
+	SKU string  `json:"sku,omitempty"`
+	Qty float64 `json:"qty,omitempty"`
 }
 
-// Validate checks if all required fields are present
+// Tracking number checks, see tracking.tracking_number_check
+const (
+	TrackingNumberCheckReject = "reject"
+	TrackingNumberCheckWarn   = "warn"
+	TrackingNumberCheckOff    = "off"
+)
+
+// Validate checks if all required fields are present and the tracking
+// number fits its carrier. Tracking number problems are reported last, as a
+// *TrackingNumberError, so callers can choose to only warn about them.
 func (t *TrackingInfo) Validate() error {
+	switch t.Action {
+	case ActionAdd, ActionReplace, ActionDelete:
+	default:
+		return fmt.Errorf("unknown action %q", t.Action)
+	}
 	if strings.TrimSpace(t.OrderNumber) == "" {
 		return fmt.Errorf("order number is required")
 	}
 	if strings.TrimSpace(t.TrackingNumber) == "" {
 		return fmt.Errorf("tracking number is required")
 	}
+	// Deleting only needs to identify the track
+	if t.Action == ActionDelete {
+		return nil
+	}
 	if strings.TrimSpace(t.CarrierCode) == "" {
 		return fmt.Errorf("carrier code is required")
 	}
 	if strings.TrimSpace(t.Title) == "" {
 		return fmt.Errorf("title is required")
 	}
-	return nil
+	if t.SKU != "" && t.Qty <= 0 {
+		return fmt.Errorf("qty must be positive for sku %s", t.SKU)
+	}
+	return t.checkTrackingNumber()
 }
 
 // MagentoOrder represents a simplified Magento order structure
 type MagentoOrder struct {
-	EntityID            int           `json:"entity_id"`
-	IncrementID         string        `json:"increment_id"`
-	Status              string        `json:"status"`
-	ExtensionAttributes ExtAttributes `json:"extension_attributes"`
+	EntityID            int                `json:"entity_id"`
+	IncrementID         string             `json:"increment_id"`
+	Status              string             `json:"status"`
+	Items               []MagentoOrderItem `json:"items"`
+	ExtensionAttributes ExtAttributes      `json:"extension_attributes"`
+}
+
+// MagentoOrderItem represents an item of a Magento order
+type MagentoOrderItem struct {
+	ItemID       int     `json:"item_id"`
+	ParentItemID int     `json:"parent_item_id,omitempty"`
+	SKU          string  `json:"sku"`
+	ProductType  string  `json:"product_type"`
+	QtyOrdered   float64 `json:"qty_ordered"`
+	QtyShipped   float64 `json:"qty_shipped"`
+	QtyRefunded  float64 `json:"qty_refunded"`
+	QtyCanceled  float64 `json:"qty_canceled"`
+}
+
+// QtyToShip returns the quantity of the item that can still be shipped
+func (i *MagentoOrderItem) QtyToShip() float64 {
+	return i.QtyOrdered - i.QtyShipped - i.QtyRefunded - i.QtyCanceled
 }
 
 // ExtAttributes represents Magento order extension attributes
@@ -67,13 +123,35 @@ type Address struct {
 
 // MagentoShipment represents a simplified Magento shipment structure
 type MagentoShipment struct {
-	EntityID    int    `json:"entity_id"`
-	IncrementID string `json:"increment_id"`
-	OrderID     int    `json:"order_id"`
+	EntityID    int                   `json:"entity_id"`
+	IncrementID string                `json:"increment_id"`
+	OrderID     int                   `json:"order_id"`
+	Items       []MagentoShipmentItem `json:"items"`
+	Tracks      []MagentoTrack        `json:"tracks"`
+}
+
+// HasSKU reports whether the shipment contains an item with the given SKU
+func (s *MagentoShipment) HasSKU(sku string) bool {
+	for _, item := range s.Items {
+		if strings.EqualFold(strings.TrimSpace(item.SKU), strings.TrimSpace(sku)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MagentoShipmentItem represents an item of a Magento shipment
+type MagentoShipmentItem struct {
+	EntityID    int     `json:"entity_id,omitempty"`
+	OrderItemID int     `json:"order_item_id"`
+	SKU         string  `json:"sku,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Qty         float64 `json:"qty"`
 }
 
 // MagentoTrack represents a Magento shipment track
 type MagentoTrack struct {
+	EntityID    int    `json:"entity_id,omitempty"`
 	OrderID     int    `json:"order_id"`
 	ParentID    int    `json:"parent_id,omitempty"` // Shipment ID
 	TrackNumber string `json:"track_number"`
@@ -81,6 +159,114 @@ type MagentoTrack struct {
 	CarrierCode string `json:"carrier_code"`
 }
 
+// Matches reports whether two tracks refer to the same tracking number and
+// carrier. An empty carrier code on either side matches any carrier.
+func (t *MagentoTrack) Matches(other *MagentoTrack) bool {
+	if !strings.EqualFold(strings.TrimSpace(t.TrackNumber), strings.TrimSpace(other.TrackNumber)) {
+		return false
+	}
+	if t.CarrierCode == "" || other.CarrierCode == "" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSpace(t.CarrierCode), strings.TrimSpace(other.CarrierCode))
+}
+
+// CustomCarrierCode is Magento's built-in carrier code for carriers without
+// a dedicated integration; the track title names the carrier
+const CustomCarrierCode = "custom"
+
+// MagentoCarrier represents a shipping carrier configured in Magento
+type MagentoCarrier struct {
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Active bool   `json:"active"`
+}
+
+// MagentoShipmentComment represents a comment on a Magento shipment
+type MagentoShipmentComment struct {
+	ParentID           int    `json:"parent_id"` // Shipment ID
+	Comment            string `json:"comment"`
+	IsCustomerNotified int    `json:"is_customer_notified"`
+	IsVisibleOnFront   int    `json:"is_visible_on_front"`
+}
+
+// MagentoOrderStatusHistory represents an order comment, which can also
+// change the order status
+type MagentoOrderStatusHistory struct {
+	ParentID           int    `json:"parent_id"` // Order ID
+	Comment            string `json:"comment"`
+	Status             string `json:"status,omitempty"`
+	IsCustomerNotified int    `json:"is_customer_notified"`
+	IsVisibleOnFront   int    `json:"is_visible_on_front"`
+}
+
+// MagentoShipOrderRequest is the body of the order ship endpoint, which
+// creates a shipment with the given items and tracks
+type MagentoShipOrderRequest struct {
+	Items         []MagentoShipItem      `json:"items"`
+	Notify        bool                   `json:"notify"`
+	AppendComment bool                   `json:"appendComment"`
+	Comment       *MagentoShipComment    `json:"comment,omitempty"`
+	Tracks        []MagentoTrackCreation `json:"tracks"`
+}
+
+// MagentoShipItem is an order item and quantity to ship
+type MagentoShipItem struct {
+	OrderItemID int     `json:"order_item_id"`
+	Qty         float64 `json:"qty"`
+}
+
+// MagentoShipComment is the comment attached to a shipment on creation
+type MagentoShipComment struct {
+	Comment          string `json:"comment"`
+	IsVisibleOnFront int    `json:"is_visible_on_front"`
+}
+
+// MagentoTrackCreation is a track attached to a shipment on creation
+type MagentoTrackCreation struct {
+	TrackNumber string `json:"track_number"`
+	Title       string `json:"title"`
+	CarrierCode string `json:"carrier_code"`
+}
+
+// Magento bulk operation statuses
+const (
+	BulkOperationComplete           = 1
+	BulkOperationRetriablyFailed    = 2
+	BulkOperationNotRetriablyFailed = 3
+	BulkOperationOpen               = 4
+	BulkOperationRejected           = 5
+)
+
+// MagentoBulkResponse represents the response to an asynchronous bulk request
+type MagentoBulkResponse struct {
+	BulkUUID     string                   `json:"bulk_uuid"`
+	RequestItems []MagentoBulkRequestItem `json:"request_items"`
+	Errors       bool                     `json:"errors"`
+}
+
+// MagentoBulkRequestItem is the acceptance status of one operation of a bulk
+// request; ID is the index of the operation in the request
+type MagentoBulkRequestItem struct {
+	ID           int    `json:"id"`
+	DataHash     string `json:"data_hash"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// MagentoBulkStatus represents the detailed status of a bulk request
+type MagentoBulkStatus struct {
+	OperationsList []MagentoBulkOperation `json:"operations_list"`
+}
+
+// MagentoBulkOperation is the status of one operation of a bulk request
+type MagentoBulkOperation struct {
+	ID            int    `json:"id"`
+	Status        int    `json:"status"`
+	ResultMessage string `json:"result_message"`
+	ErrorCode     int    `json:"error_code"`
+}
+
 // MagentoShipmentResponse represents the response from Magento API for shipment queries
 type MagentoShipmentResponse struct {
 	Items []MagentoShipment `json:"items"`
@@ -0,0 +1,255 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Magento carrier codes of the carriers with a dedicated integration
+const (
+	CarrierUPS   = "ups"
+	CarrierUSPS  = "usps"
+	CarrierFedEx = "fedex"
+	CarrierDHL   = "dhl"
+)
+
+// DetectedCarrier is the carrier a tracking number format belongs to.
+// Carriers without a Magento integration use the custom carrier code.
+type DetectedCarrier struct {
+	Code  string
+	Title string
+}
+
+var (
+	detectedUPS   = DetectedCarrier{Code: CarrierUPS, Title: "United Parcel Service"}
+	detectedUSPS  = DetectedCarrier{Code: CarrierUSPS, Title: "United States Postal Service"}
+	detectedFedEx = DetectedCarrier{Code: CarrierFedEx, Title: "Federal Express"}
+	detectedDHL   = DetectedCarrier{Code: CarrierDHL, Title: "DHL"}
+)
+
+// s10Carriers maps the country suffix of S10 international numbers to the
+// postal operator issuing them
+var s10Carriers = map[string]DetectedCarrier{
+	"US": detectedUSPS,
+	"GB": {Code: CustomCarrierCode, Title: "Royal Mail"},
+	"CA": {Code: CustomCarrierCode, Title: "Canada Post"},
+	"DE": {Code: CustomCarrierCode, Title: "Deutsche Post"},
+	"FR": {Code: CustomCarrierCode, Title: "La Poste"},
+	"NL": {Code: CustomCarrierCode, Title: "PostNL"},
+	"AU": {Code: CustomCarrierCode, Title: "Australia Post"},
+}
+
+// trackingFormat is a tracking number format of one carrier
+type trackingFormat struct {
+	carrier DetectedCarrier
+	pattern *regexp.Regexp
+	valid   func(number string) bool // Check digit validation
+}
+
+// trackingFormats are tried in order, so more specific formats come first
+var trackingFormats = []trackingFormat{
+	{detectedUPS, regexp.MustCompile(`^1Z[0-9A-Z]{16}$`), upsCheckDigit},
+	{detectedUSPS, regexp.MustCompile(`^9[1-5](\d{18}|\d{20})$`), mod10CheckDigit},
+	{detectedFedEx, regexp.MustCompile(`^96\d{20}$`), fedExGround96CheckDigit},
+	{detectedFedEx, regexp.MustCompile(`^\d{12}$`), fedExExpressCheckDigit},
+	{detectedFedEx, regexp.MustCompile(`^\d{15}$`), mod10CheckDigit},
+	{detectedFedEx, regexp.MustCompile(`^[0-8]\d{19}$`), mod10CheckDigit},
+	{detectedDHL, regexp.MustCompile(`^\d{10}$`), dhlExpressCheckDigit},
+}
+
+// s10Pattern matches UPU S10 international numbers such as RR123456785GB
+var s10Pattern = regexp.MustCompile(`^[A-Z]{2}\d{9}[A-Z]{2}$`)
+
+// s10Format is the UPU S10 format used by postal operators worldwide
+var s10Format = trackingFormat{pattern: s10Pattern, valid: s10CheckDigit}
+
+// carrierFormats lists the tracking number formats each carrier issues, by
+// Magento carrier code. Formats without a check digit only check structure.
+var carrierFormats = map[string][]trackingFormat{
+	CarrierUPS: {
+		{pattern: regexp.MustCompile(`^1Z[0-9A-Z]{16}$`), valid: upsCheckDigit},
+		{pattern: regexp.MustCompile(`^T\d{10}$`)},                                       // Freight
+		{pattern: regexp.MustCompile(`^\d{9}$`)},                                         // InfoNotice
+		{pattern: regexp.MustCompile(`^9[1-5](\d{18}|\d{20})$`), valid: mod10CheckDigit}, // Mail Innovations
+	},
+	CarrierUSPS: {
+		{pattern: regexp.MustCompile(`^9[1-5](\d{18}|\d{20})$`), valid: mod10CheckDigit}, // IMpb
+		{pattern: regexp.MustCompile(`^\d{20}$`), valid: mod10CheckDigit},
+		s10Format,
+	},
+	CarrierFedEx: {
+		{pattern: regexp.MustCompile(`^\d{12}$`), valid: fedExExpressCheckDigit},
+		{pattern: regexp.MustCompile(`^\d{15}$`), valid: mod10CheckDigit},
+		{pattern: regexp.MustCompile(`^\d{20}$`), valid: mod10CheckDigit},
+		{pattern: regexp.MustCompile(`^96\d{20}$`), valid: fedExGround96CheckDigit},
+	},
+	CarrierDHL: {
+		{pattern: regexp.MustCompile(`^\d{10}$`), valid: dhlExpressCheckDigit},
+		{pattern: regexp.MustCompile(`^J{1,2}D\d{16,18}$`)}, // DHL Parcel
+		s10Format,
+	},
+}
+
+// TrackingNumberError reports a tracking number that matches none of the
+// formats of its carrier or fails their check digit
+type TrackingNumberError struct {
+	TrackingNumber string
+	CarrierCode    string
+	Reason         string
+}
+
+// Error implements the error interface
+func (e *TrackingNumberError) Error() string {
+	return fmt.Sprintf("tracking number %s %s", e.TrackingNumber, e.Reason)
+}
+
+// checkTrackingNumber validates the structure and check digit of the tracking
+// number against the formats of its carrier. Numbers of carriers without
+// known formats are only checked when they have the S10 format.
+func (t *TrackingInfo) checkTrackingNumber() error {
+	number := cleanTrackingNumber(t.TrackingNumber)
+	code := strings.ToLower(strings.TrimSpace(t.CarrierCode))
+
+	formats, ok := carrierFormats[code]
+	if !ok {
+		formats = []trackingFormat{s10Format}
+	}
+
+	checkFailed := false
+	for _, format := range formats {
+		if !format.pattern.MatchString(number) {
+			continue
+		}
+		if format.valid == nil || format.valid(number) {
+			return nil
+		}
+		checkFailed = true
+	}
+
+	switch {
+	case checkFailed:
+		return &TrackingNumberError{TrackingNumber: t.TrackingNumber, CarrierCode: t.CarrierCode, Reason: "has an invalid check digit"}
+	case ok:
+		return &TrackingNumberError{TrackingNumber: t.TrackingNumber, CarrierCode: t.CarrierCode,
+			Reason: fmt.Sprintf("does not match any %s tracking number format", code)}
+	}
+	return nil
+}
+
+// cleanTrackingNumber removes the spaces and dashes partners use to group
+// the characters of a tracking number and upper-cases it
+func cleanTrackingNumber(number string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(number)))
+}
+
+// DetectCarrier classifies a tracking number by its format and check digit.
+// It returns false when the number matches no known format.
+func DetectCarrier(trackingNumber string) (DetectedCarrier, bool) {
+	number := cleanTrackingNumber(trackingNumber)
+
+	if s10Pattern.MatchString(number) && s10CheckDigit(number) {
+		carrier, ok := s10Carriers[number[11:]]
+		return carrier, ok
+	}
+
+	for _, format := range trackingFormats {
+		if format.pattern.MatchString(number) && format.valid(number) {
+			return format.carrier, true
+		}
+	}
+	return DetectedCarrier{}, false
+}
+
+// Matches reports whether the carrier code and title refer to this carrier.
+// Titles only matter for the custom carrier.
+func (c DetectedCarrier) Matches(code, title string) bool {
+	if !strings.EqualFold(strings.TrimSpace(code), c.Code) {
+		return false
+	}
+	return c.Code != CustomCarrierCode || strings.EqualFold(strings.TrimSpace(title), c.Title)
+}
+
+// digit returns the value of a decimal digit character
+func digit(c byte) int {
+	return int(c - '0')
+}
+
+// upsCheckDigit validates 1Z numbers: letters count as (position in the
+// alphabet + 1) mod 10, every second character is doubled and the check digit
+// brings the sum to a multiple of 10
+func upsCheckDigit(number string) bool {
+	sum := 0
+	for i, c := range []byte(number[2:17]) {
+		n := 0
+		if c >= 'A' && c <= 'Z' {
+			n = int(c-'A'+2) % 10
+		} else {
+			n = digit(c)
+		}
+		if i%2 == 1 {
+			n *= 2
+		}
+		sum += n
+	}
+	return (10-sum%10)%10 == digit(number[17])
+}
+
+// mod10CheckDigit validates the USPS/GS1 mod 10 check digit: digits are
+// weighted 3 and 1 alternately from the right, starting next to the check digit
+func mod10CheckDigit(number string) bool {
+	sum := 0
+	weight := 3
+	for i := len(number) - 2; i >= 0; i-- {
+		sum += digit(number[i]) * weight
+		weight = 4 - weight
+	}
+	return (10-sum%10)%10 == digit(number[len(number)-1])
+}
+
+// fedExExpressCheckDigit validates 12-digit FedEx Express numbers: the first
+// eleven digits are weighted 3, 1, 7 repeatedly, and the sum mod 11 mod 10
+// is the check digit
+func fedExExpressCheckDigit(number string) bool {
+	weights := [3]int{3, 1, 7}
+	sum := 0
+	for i := 0; i < 11; i++ {
+		sum += digit(number[i]) * weights[i%3]
+	}
+	return sum%11%10 == digit(number[11])
+}
+
+// fedExGround96CheckDigit validates 22-digit FedEx Ground numbers, whose
+// check digit covers the trailing 15-digit package identifier
+func fedExGround96CheckDigit(number string) bool {
+	return mod10CheckDigit(number[7:])
+}
+
+// dhlExpressCheckDigit validates 10-digit DHL Express waybills, whose check
+// digit is the first nine digits mod 7
+func dhlExpressCheckDigit(number string) bool {
+	n := 0
+	for i := 0; i < 9; i++ {
+		n = (n*10 + digit(number[i])) % 7
+	}
+	return n == digit(number[9])
+}
+
+// s10CheckDigit validates UPU S10 numbers: the eight serial digits are
+// weighted 8, 6, 4, 2, 3, 5, 9, 7 and the check digit is 11 minus the sum
+// mod 11, with 10 written as 0 and 11 as 5
+func s10CheckDigit(number string) bool {
+	weights := [8]int{8, 6, 4, 2, 3, 5, 9, 7}
+	sum := 0
+	for i, w := range weights {
+		sum += digit(number[2+i]) * w
+	}
+	check := 11 - sum%11
+	switch check {
+	case 10:
+		check = 0
+	case 11:
+		check = 5
+	}
+	return check == digit(number[10])
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// trackingNumberCases are published sample numbers of each carrier, the same
+// numbers with two adjacent characters transposed, and numbers that match no
+// format of their carrier
+var trackingNumberCases = []struct {
+	name    string
+	carrier string
+	number  string
+	reason  string // empty when the number is valid
+}{
+	// UPS 1Z, mod 10 over the characters after 1Z
+	{"ups valid", CarrierUPS, "1Z999AA10123456784", ""},
+	{"ups valid grouped", CarrierUPS, "1z 999 aa1 0123 4567 84", ""},
+	{"ups valid second", CarrierUPS, "1Z12345E6605272234", ""},
+	{"ups transposed", CarrierUPS, "1Z999AA10123465784", "has an invalid check digit"},
+	{"ups wrong check digit", CarrierUPS, "1Z999AA10123456785", "has an invalid check digit"},
+	{"ups unknown format", CarrierUPS, "1Z999AA1012345678", "does not match any ups tracking number format"},
+
+	// USPS IMpb, mod 10
+	{"usps impb valid", CarrierUSPS, "9205590164917312751089", ""},
+	{"usps impb transposed", CarrierUSPS, "9205590164917321751089", "has an invalid check digit"},
+	{"usps impb wrong check digit", CarrierUSPS, "9205590164917312751088", "has an invalid check digit"},
+	{"usps unknown format", CarrierUSPS, "1Z999AA10123456784", "does not match any usps tracking number format"},
+
+	// FedEx Express 12 digits, weights 3, 1 and 7, mod 11
+	{"fedex express valid", CarrierFedEx, "986578788855", ""},
+	{"fedex express valid second", CarrierFedEx, "797806677146", ""},
+	{"fedex express transposed", CarrierFedEx, "896578788855", "has an invalid check digit"},
+	{"fedex express wrong check digit", CarrierFedEx, "111111111111", "has an invalid check digit"},
+
+	// FedEx Ground 15 digits, mod 10
+	{"fedex ground valid", CarrierFedEx, "020207021381215", ""},
+	{"fedex ground transposed", CarrierFedEx, "020207012381215", "has an invalid check digit"},
+
+	// FedEx Ground 96, mod 10 over the last 15 digits
+	{"fedex ground 96 valid", CarrierFedEx, "9611020987654312345672", ""},
+	{"fedex ground 96 transposed", CarrierFedEx, "9611020987654321345672", "has an invalid check digit"},
+	{"fedex unknown format", CarrierFedEx, "12345", "does not match any fedex tracking number format"},
+
+	// DHL Express 10 digits, mod 7
+	{"dhl express valid", CarrierDHL, "3318810025", ""},
+	{"dhl express valid second", CarrierDHL, "8564385550", ""},
+	{"dhl express transposed", CarrierDHL, "3318810205", "has an invalid check digit"},
+	{"dhl express wrong check digit", CarrierDHL, "1234567890", "has an invalid check digit"},
+
+	// UPU S10, weights 8, 6, 4, 2, 3, 5, 9, 7, mod 11
+	{"s10 valid", CarrierUSPS, "EE123456785US", ""},
+	{"s10 valid dhl", CarrierDHL, "RA473124829DE", ""},
+	{"s10 valid other carrier", "custom", "AA473124829GB", ""},
+	{"s10 transposed", "custom", "AA473142829GB", "has an invalid check digit"},
+	{"s10 wrong check digit", CarrierUSPS, "RR123456784US", "has an invalid check digit"},
+
+	// Carriers without formats only check S10 numbers
+	{"other carrier free format", "custom", "ABC-123", ""},
+}
+
+func TestCheckTrackingNumber(t *testing.T) {
+	for _, tc := range trackingNumberCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &TrackingInfo{CarrierCode: tc.carrier, TrackingNumber: tc.number}
+			err := info.checkTrackingNumber()
+			if tc.reason == "" {
+				if err != nil {
+					t.Fatalf("checkTrackingNumber(%s, %s) = %v, want nil", tc.carrier, tc.number, err)
+				}
+				return
+			}
+
+			var numberErr *TrackingNumberError
+			if !errors.As(err, &numberErr) {
+				t.Fatalf("checkTrackingNumber(%s, %s) = %v, want a *TrackingNumberError", tc.carrier, tc.number, err)
+			}
+			if numberErr.Reason != tc.reason {
+				t.Errorf("reason = %q, want %q", numberErr.Reason, tc.reason)
+			}
+			if numberErr.TrackingNumber != tc.number || numberErr.CarrierCode != tc.carrier {
+				t.Errorf("error reports %s/%s, want %s/%s",
+					numberErr.CarrierCode, numberErr.TrackingNumber, tc.carrier, tc.number)
+			}
+		})
+	}
+}
+
+func TestDetectCarrier(t *testing.T) {
+	tests := []struct {
+		number string
+		code   string
+		title  string
+		ok     bool
+	}{
+		{"1Z999AA10123456784", CarrierUPS, "United Parcel Service", true},
+		{"9205590164917312751089", CarrierUSPS, "United States Postal Service", true},
+		{"986578788855", CarrierFedEx, "Federal Express", true},
+		{"020207021381215", CarrierFedEx, "Federal Express", true},
+		{"9611020987654312345672", CarrierFedEx, "Federal Express", true},
+		{"3318810025", CarrierDHL, "DHL", true},
+		{"EE123456785US", CarrierUSPS, "United States Postal Service", true},
+		{"AA473124829GB", "custom", "Royal Mail", true},
+		{"RA473124829DE", "custom", "Deutsche Post", true},
+		{"CP123456785CA", "custom", "Canada Post", true},
+
+		// Failed check digits and unknown formats are not detected
+		{"1Z999AA10123465784", "", "", false},
+		{"3318810205", "", "", false},
+		{"AA473142829GB", "", "", false},
+		{"ABC-123", "", "", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.number, func(t *testing.T) {
+			detected, ok := DetectCarrier(tc.number)
+			if ok != tc.ok {
+				t.Fatalf("DetectCarrier(%s) ok = %v, want %v", tc.number, ok, tc.ok)
+			}
+			if detected.Code != tc.code || detected.Title != tc.title {
+				t.Errorf("DetectCarrier(%s) = %s/%s, want %s/%s",
+					tc.number, detected.Code, detected.Title, tc.code, tc.title)
+			}
+		})
+	}
+}
+
+func TestValidateTrackingNumber(t *testing.T) {
+	info := &TrackingInfo{
+		Action:         ActionAdd,
+		OrderNumber:    "000000123",
+		TrackingNumber: "1Z999AA10123465784",
+		CarrierCode:    CarrierUPS,
+		Title:          "United Parcel Service",
+	}
+	err := info.Validate()
+	var numberErr *TrackingNumberError
+	if !errors.As(err, &numberErr) {
+		t.Fatalf("Validate() = %v, want a *TrackingNumberError", err)
+	}
+	if !strings.Contains(err.Error(), "1Z999AA10123465784 has an invalid check digit") {
+		t.Errorf("Validate() = %q", err)
+	}
+
+	// Deleting does not check the tracking number
+	info.Action = ActionDelete
+	if err := info.Validate(); err != nil {
+		t.Errorf("Validate() for delete = %v, want nil", err)
+	}
+}
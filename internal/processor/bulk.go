@@ -0,0 +1,297 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+	"tracking-updater/internal/tracing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// bulkTrack is a track queued for bulk submission, together with what is
+// needed to finish its row once Magento processed it
+type bulkTrack struct {
+	row          int // Index into FileResult.Rows
+	client       *api.MagentoClient
+	trackingInfo *model.TrackingInfo
+	order        *model.MagentoOrder
+	track        model.MagentoTrack
+
+	// Bulk and operation index the track was submitted as, set once Magento
+	// accepted the bulk
+	bulkUUID  string
+	operation int
+
+	// Operation status as reported by Magento
+	status  int
+	message string
+}
+
+// queueBulkTrack queues a track for the current row
+func (f *fileState) queueBulkTrack(client *api.MagentoClient, trackingInfo *model.TrackingInfo, order *model.MagentoOrder, shipment *model.MagentoShipment, track *model.MagentoTrack) {
+	t := *track
+	t.ParentID = shipment.EntityID
+
+	f.addBulkTrack(&bulkTrack{
+		row:          f.row,
+		client:       client,
+		trackingInfo: trackingInfo,
+		order:        order,
+		track:        t,
+	})
+}
+
+// addBulkTrack adds a track to the queued tracks of its row
+func (f *fileState) addBulkTrack(t *bulkTrack) {
+	f.bulkTracks = append(f.bulkTracks, t)
+	f.bulkRows[t.row] = append(f.bulkRows[t.row], t)
+}
+
+// bulkSubmitted reports whether Magento accepted every queued track of a row
+func (f *fileState) bulkSubmitted(row int) bool {
+	tracks := f.bulkRows[row]
+	for _, t := range tracks {
+		if t.bulkUUID == "" {
+			return false
+		}
+	}
+	return len(tracks) > 0
+}
+
+// restoreBulkRow queues the submitted tracks of a row restored from the
+// checkpoint, so their bulks are polled instead of submitted again
+func (p *CSVProcessor) restoreBulkRow(state *fileState, row int, bulk *checkpointBulk) {
+	trackingInfo := bulk.TrackingInfo
+	order := bulk.Order
+	client := p.router.ClientFor(state.path, trackingInfo.OrderNumber)
+
+	for _, op := range bulk.Operations {
+		state.addBulkTrack(&bulkTrack{
+			row:          row,
+			client:       client,
+			trackingInfo: &trackingInfo,
+			order:        &order,
+			track:        op.Track,
+			bulkUUID:     op.BulkUUID,
+			operation:    op.Operation,
+			status:       op.Status,
+			message:      op.Message,
+		})
+	}
+}
+
+// submitBulkTracks submits the queued tracks per Magento client in batches,
+// waits for Magento to process them and maps the operation results back onto
+// the rows. commit journals the rows of each accepted batch. It returns the
+// number of rows that failed. When interrupted by shutdown, the file is
+// marked interrupted and its rows are left pending.
+func (p *CSVProcessor) submitBulkTracks(ctx context.Context, file *fileState, result *model.FileResult, commit func(final bool)) int {
+	if len(file.bulkTracks) == 0 {
+		return 0
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "submit bulk tracks", trace.WithAttributes(attribute.Int("track_count", len(file.bulkTracks))))
+	defer span.End()
+
+	// Tracks restored from the checkpoint were submitted before a restart
+	submitted := make(map[string][]*bulkTrack)
+	var bulkUUIDs []string
+	byClient := make(map[*api.MagentoClient][]*bulkTrack)
+	var clients []*api.MagentoClient
+	for _, t := range file.bulkTracks {
+		if t.bulkUUID != "" {
+			if _, ok := submitted[t.bulkUUID]; !ok {
+				bulkUUIDs = append(bulkUUIDs, t.bulkUUID)
+			}
+			submitted[t.bulkUUID] = append(submitted[t.bulkUUID], t)
+			continue
+		}
+		if _, ok := byClient[t.client]; !ok {
+			clients = append(clients, t.client)
+		}
+		byClient[t.client] = append(byClient[t.client], t)
+	}
+
+	for _, bulkUUID := range bulkUUIDs {
+		tracks := submitted[bulkUUID]
+		p.logger.WithContext(ctx).WithField("bulk_uuid", bulkUUID).Info("Resuming bulk submitted before the restart")
+		if !p.pollBulk(ctx, tracks[0].client, bulkUUID, tracks) {
+			file.interrupted = true
+			return 0
+		}
+	}
+
+	batchSize := p.config.Load().Magento.Bulk.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(file.bulkTracks)
+	}
+
+	for _, client := range clients {
+		queued := byClient[client]
+		for start := 0; start < len(queued); start += batchSize {
+			end := start + batchSize
+			if end > len(queued) {
+				end = len(queued)
+			}
+			if p.interrupted(ctx) || !p.submitBulkBatch(ctx, client, queued[start:end], result, commit) {
+				file.interrupted = true
+				return 0
+			}
+		}
+	}
+
+	return p.finishBulkRows(ctx, file, result)
+}
+
+// submitBulkBatch submits one bulk request, journals its rows once Magento
+// accepted it and polls it until every operation finished or the poll timeout
+// expired. It reports false when polling was interrupted by shutdown.
+func (p *CSVProcessor) submitBulkBatch(ctx context.Context, client *api.MagentoClient, batch []*bulkTrack, result *model.FileResult, commit func(final bool)) bool {
+	tracks := make([]model.MagentoTrack, len(batch))
+	for i, t := range batch {
+		tracks[i] = t.track
+	}
+
+	response, err := client.AddTracksAsync(ctx, tracks)
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		for _, t := range batch {
+			t.status = model.BulkOperationNotRetriablyFailed
+			t.message = err.Error()
+		}
+		return true
+	}
+
+	for i, t := range batch {
+		result.Rows[t.row].BulkUUID = response.BulkUUID
+		t.bulkUUID = response.BulkUUID
+		t.operation = i
+		t.status = model.BulkOperationOpen
+	}
+	for _, item := range response.RequestItems {
+		if item.Status == "rejected" && item.ID >= 0 && item.ID < len(batch) {
+			batch[item.ID].status = model.BulkOperationRejected
+			batch[item.ID].message = item.ErrorMessage
+		}
+	}
+
+	// A restart from here on polls the bulk rather than submitting it again
+	commit(false)
+
+	return p.pollBulk(ctx, client, response.BulkUUID, batch)
+}
+
+// pollBulk refreshes the operation statuses of a bulk until none of the
+// given tracks is open. It reports false when interrupted by shutdown before
+// that.
+func (p *CSVProcessor) pollBulk(ctx context.Context, client *api.MagentoClient, bulkUUID string, tracks []*bulkTrack) bool {
+	log := p.logger.WithContext(ctx).WithField("bulk_uuid", bulkUUID)
+	deadline := time.Now().Add(p.config.Load().Magento.Bulk.PollTimeout)
+
+	byOperation := make(map[int]*bulkTrack, len(tracks))
+	for _, t := range tracks {
+		byOperation[t.operation] = t
+	}
+
+	for {
+		status, err := client.GetBulkStatus(ctx, bulkUUID)
+		if err != nil {
+			log.WithError(err).Warn("Failed to poll bulk status")
+		} else {
+			for _, op := range status.OperationsList {
+				if t, ok := byOperation[op.ID]; ok {
+					t.status = op.Status
+					t.message = op.ResultMessage
+				}
+			}
+
+			open := 0
+			for _, t := range tracks {
+				if t.status == model.BulkOperationOpen {
+					open++
+				}
+			}
+			if open == 0 {
+				log.Info("Bulk operations completed")
+				return true
+			}
+			log.WithField("open", open).Debug("Waiting for bulk operations")
+		}
+
+		if time.Now().After(deadline) {
+			log.Warn("Bulk operations not completed before the poll timeout, leaving rows pending")
+			return true
+		}
+
+		timer := time.NewTimer(p.config.Load().Magento.Bulk.PollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			log.Warn("Interrupted while waiting for bulk operations, leaving rows pending")
+			return false
+		case <-p.interruptChan:
+			timer.Stop()
+			log.Warn("Interrupted while waiting for bulk operations, leaving rows pending")
+			return false
+		}
+	}
+}
+
+// finishBulkRows sets the outcome of every row with queued tracks and runs the
+// follow-up calls for rows whose tracks were all stored. It returns the number
+// of rows that failed.
+func (p *CSVProcessor) finishBulkRows(ctx context.Context, file *fileState, result *model.FileResult) int {
+	byRow := make(map[int][]*bulkTrack)
+	var rows []int
+	for _, t := range file.bulkTracks {
+		if _, ok := byRow[t.row]; !ok {
+			rows = append(rows, t.row)
+		}
+		byRow[t.row] = append(byRow[t.row], t)
+	}
+
+	failed := 0
+	for _, row := range rows {
+		tracks := byRow[row]
+		rowResult := &result.Rows[row]
+
+		complete := 0
+		for _, t := range tracks {
+			switch t.status {
+			case model.BulkOperationComplete:
+				complete++
+			case model.BulkOperationRetriablyFailed, model.BulkOperationNotRetriablyFailed, model.BulkOperationRejected:
+				if rowResult.Outcome != model.OutcomeFailed {
+					rowResult.Outcome = model.OutcomeFailed
+					rowResult.Error = fmt.Sprintf("bulk operation failed: %s", t.message)
+					failed++
+				}
+			}
+		}
+		if rowResult.Outcome == model.OutcomeFailed || complete < len(tracks) {
+			continue
+		}
+
+		rowResult.Outcome = model.OutcomeSuccess
+		p.logger.WithContext(ctx).WithFields(logrus.Fields{
+			"order_number":    tracks[0].trackingInfo.OrderNumber,
+			"tracking_number": tracks[0].trackingInfo.TrackingNumber,
+		}).Info("Successfully updated tracking information")
+
+		for _, t := range tracks {
+			p.afterTrack(ctx, t.client, file, t.trackingInfo, t.track.ParentID, rowResult)
+		}
+		p.updateOrderStatus(ctx, tracks[0].client, tracks[0].order, rowResult)
+	}
+
+	return failed
+}
@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/model"
+)
+
+func TestPollBulkMapsOperationsToTracks(t *testing.T) {
+	tests := []struct {
+		name       string
+		operations []model.MagentoBulkOperation
+		want       []int // Status of each track after polling
+		wantMsg    []string
+	}{
+		{
+			"all complete",
+			[]model.MagentoBulkOperation{{ID: 5, Status: model.BulkOperationComplete}, {ID: 6, Status: model.BulkOperationComplete}, {ID: 7, Status: model.BulkOperationComplete}},
+			[]int{model.BulkOperationComplete, model.BulkOperationComplete, model.BulkOperationComplete},
+			[]string{"", "", ""},
+		},
+		{
+			"out of order with failure",
+			[]model.MagentoBulkOperation{{ID: 7, Status: model.BulkOperationComplete}, {ID: 5, Status: model.BulkOperationNotRetriablyFailed, ResultMessage: "shipment not found"}, {ID: 6, Status: model.BulkOperationComplete}},
+			[]int{model.BulkOperationNotRetriablyFailed, model.BulkOperationComplete, model.BulkOperationComplete},
+			[]string{"shipment not found", "", ""},
+		},
+		{
+			"operations of other batches ignored",
+			[]model.MagentoBulkOperation{{ID: 0, Status: model.BulkOperationRejected}, {ID: 5, Status: model.BulkOperationComplete}, {ID: 6, Status: model.BulkOperationRetriablyFailed, ResultMessage: "locked"}, {ID: 7, Status: model.BulkOperationComplete}},
+			[]int{model.BulkOperationComplete, model.BulkOperationRetriablyFailed, model.BulkOperationComplete},
+			[]string{"", "locked", ""},
+		},
+		{
+			"open past the poll timeout",
+			[]model.MagentoBulkOperation{{ID: 5, Status: model.BulkOperationComplete}, {ID: 6, Status: model.BulkOperationOpen}},
+			[]int{model.BulkOperationComplete, model.BulkOperationOpen, model.BulkOperationOpen},
+			[]string{"", "", ""},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/bulk/bulk-1/detailed-status" {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(model.MagentoBulkStatus{OperationsList: tc.operations})
+			}))
+			defer server.Close()
+
+			p := newTestProcessor(&config.Config{Magento: config.MagentoConfig{BaseURL: server.URL, MaxRetries: 1}})
+			client := p.router.ClientFor("tracking.csv", "000000001")
+
+			// A batch submitted as operations 5 to 7 of its bulk
+			tracks := make([]*bulkTrack, len(tc.want))
+			for i := range tracks {
+				tracks[i] = &bulkTrack{row: i, client: client, operation: 5 + i, status: model.BulkOperationOpen}
+			}
+
+			if !p.pollBulk(context.Background(), client, "bulk-1", tracks) {
+				t.Fatal("pollBulk() reported an interruption")
+			}
+
+			var got []int
+			var gotMsg []string
+			for _, track := range tracks {
+				got = append(got, track.status)
+				gotMsg = append(gotMsg, track.message)
+			}
+			if !reflect.DeepEqual(got, tc.want) || !reflect.DeepEqual(gotMsg, tc.wantMsg) {
+				t.Errorf("statuses = %v %q, want %v %q", got, gotMsg, tc.want, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestFinishBulkRows(t *testing.T) {
+	failed := model.BulkOperationNotRetriablyFailed
+	complete := model.BulkOperationComplete
+	open := model.BulkOperationOpen
+
+	tests := []struct {
+		name       string
+		statuses   [][]int // Track statuses per row, in queue order
+		want       []string
+		wantFailed int
+	}{
+		{"complete rows", [][]int{{complete}, {complete, complete}}, []string{model.OutcomeSuccess, model.OutcomeSuccess}, 0},
+		{"failed track fails its row only", [][]int{{complete, failed}, {complete}}, []string{model.OutcomeFailed, model.OutcomeSuccess}, 1},
+		{"several failed tracks count once", [][]int{{failed, model.BulkOperationRejected}}, []string{model.OutcomeFailed}, 1},
+		{"retriably failed", [][]int{{model.BulkOperationRetriablyFailed}}, []string{model.OutcomeFailed}, 1},
+		{"open track leaves row pending", [][]int{{complete, open}, {complete}}, []string{model.OutcomePending, model.OutcomeSuccess}, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProcessor(&config.Config{})
+			state := newFileState("tracking.csv", &config.TrackingConfig{}, nil)
+			result := &model.FileResult{}
+
+			// Queue the tracks of all rows interleaved, as several shipments
+			// of the same rows would be
+			for row := range tc.statuses {
+				result.Rows = append(result.Rows, model.RowResult{Line: row + 2, Outcome: model.OutcomePending})
+			}
+			for i := 0; ; i++ {
+				queued := false
+				for row, statuses := range tc.statuses {
+					if i < len(statuses) {
+						state.addBulkTrack(&bulkTrack{
+							row:          row,
+							trackingInfo: &model.TrackingInfo{},
+							order:        &model.MagentoOrder{},
+							status:       statuses[i],
+							message:      "rejected",
+						})
+						queued = true
+					}
+				}
+				if !queued {
+					break
+				}
+			}
+
+			if got := p.finishBulkRows(context.Background(), state, result); got != tc.wantFailed {
+				t.Errorf("finishBulkRows() = %d failed rows, want %d", got, tc.wantFailed)
+			}
+			for row, want := range tc.want {
+				if got := result.Rows[row].Outcome; got != want {
+					t.Errorf("row %d outcome = %s, want %s", row, got, want)
+				}
+				if result.Rows[row].Outcome == model.OutcomeFailed && result.Rows[row].Error != "bulk operation failed: rejected" {
+					t.Errorf("row %d error = %q", row, result.Rows[row].Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// carrierRegistry keeps the carrier codes known to each Magento instance,
+// loaded from Magento or, until Magento provided them once, from the
+// configuration
+type carrierRegistry struct {
+	config   *config.CarriersConfig
+	logger   *logrus.Logger
+	mutex    sync.RWMutex
+	carriers map[*api.MagentoClient]map[string]string // Lower-case code to code
+	fetched  map[*api.MagentoClient]bool              // Whether carriers came from Magento
+}
+
+// newCarrierRegistry creates an empty carrier registry
+func newCarrierRegistry(cfg *config.CarriersConfig, logger *logrus.Logger) *carrierRegistry {
+	return &carrierRegistry{
+		config:   cfg,
+		logger:   logger,
+		carriers: make(map[*api.MagentoClient]map[string]string),
+		fetched:  make(map[*api.MagentoClient]bool),
+	}
+}
+
+// refresh reloads the carriers of the given clients
+func (r *carrierRegistry) refresh(ctx context.Context, clients []*api.MagentoClient) {
+	for _, client := range clients {
+		r.load(ctx, client)
+	}
+}
+
+// load fetches the carriers of a client. When Magento cannot provide them,
+// the last list fetched is kept, and the configured list is used only when
+// none was fetched yet.
+func (r *carrierRegistry) load(ctx context.Context, client *api.MagentoClient) map[string]string {
+	var codes []string
+
+	carriers, err := client.GetCarriers(ctx, r.config.Endpoint)
+	if err != nil || len(carriers) == 0 {
+		r.mutex.RLock()
+		known, loaded := r.carriers[client]
+		fetched := r.fetched[client]
+		r.mutex.RUnlock()
+
+		switch {
+		case fetched:
+			r.logger.WithError(err).Warn("Failed to refresh carriers from Magento, keeping the last list")
+			return known
+		case loaded:
+			// Already on the configured list; warned when it was first used
+			r.logger.WithError(err).Debug("Carriers still unavailable from Magento, using configured carriers")
+			return known
+		}
+		r.logger.WithError(err).Warn("Carriers unavailable from Magento, using configured carriers")
+		codes = r.config.Known
+	} else {
+		for _, carrier := range carriers {
+			codes = append(codes, carrier.Code)
+		}
+	}
+	fromMagento := err == nil && len(carriers) > 0
+
+	// Without any list there is nothing to validate against
+	if len(codes) == 0 {
+		r.logger.Warn("No carriers known, carrier codes are not validated")
+		r.mutex.Lock()
+		r.carriers[client] = nil
+		r.fetched[client] = false
+		r.mutex.Unlock()
+		return nil
+	}
+
+	known := make(map[string]string)
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		known[strings.ToLower(code)] = code
+	}
+	// Magento always accepts custom carriers
+	known[model.CustomCarrierCode] = model.CustomCarrierCode
+
+	r.mutex.Lock()
+	r.carriers[client] = known
+	r.fetched[client] = fromMagento
+	r.mutex.Unlock()
+
+	return known
+}
+
+// knownTo returns the carriers of a client, loading them on first use. It
+// returns nil when no carriers are known.
+func (r *carrierRegistry) knownTo(ctx context.Context, client *api.MagentoClient) map[string]string {
+	r.mutex.RLock()
+	known, ok := r.carriers[client]
+	r.mutex.RUnlock()
+
+	if !ok {
+		known = r.load(ctx, client)
+	}
+	return known
+}
+
+// normalize checks the row's carrier code against the carriers known to the
+// client and rewrites it to Magento's spelling. Unknown carriers become custom
+// carriers titled with the original name when configured, and fail otherwise.
+func (r *carrierRegistry) normalize(ctx context.Context, client *api.MagentoClient, trackingInfo *model.TrackingInfo) error {
+	if !r.config.Validate || strings.TrimSpace(trackingInfo.CarrierCode) == "" {
+		return nil
+	}
+
+	known := r.knownTo(ctx, client)
+	if known == nil {
+		return nil
+	}
+
+	original := strings.TrimSpace(trackingInfo.CarrierCode)
+	if code, ok := known[strings.ToLower(original)]; ok {
+		trackingInfo.CarrierCode = code
+		return nil
+	}
+
+	if r.config.UnknownToCustom {
+		r.logger.WithField("carrier_code", original).Debug("Unknown carrier, using custom carrier")
+		trackingInfo.CarrierCode = model.CustomCarrierCode
+		trackingInfo.Title = original
+		return nil
+	}
+
+	return fmt.Errorf("unknown carrier code %q", original)
+}
+
+// newCarrierNormalizer creates the carrier alias normalizer from the config
+func newCarrierNormalizer(aliases []config.CarrierAlias) *model.CarrierNormalizer {
+	converted := make([]model.CarrierAlias, len(aliases))
+	for i, alias := range aliases {
+		converted[i] = model.CarrierAlias{Code: alias.Code, Title: alias.Title, Names: alias.Names}
+	}
+	return model.NewCarrierNormalizer(converted)
+}
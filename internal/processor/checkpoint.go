@@ -0,0 +1,352 @@
+package processor
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkpointSuffix is appended to the CSV file name to name its checkpoint
+const checkpointSuffix = ".checkpoint.jsonl"
+
+// checkpoint is the journal of a file's committed rows, so processing resumes
+// after the last committed row after a shutdown or crash instead of applying
+// every row again. The first line is a checkpointHeader, every further line a
+// checkpointEntry; lines are synced to disk as rows are committed.
+type checkpoint struct {
+	path      string
+	file      *os.File
+	committed int          // Number of rows in the journal
+	pending   map[int]bool // Rows journaled while their bulk operations were open
+	failed    bool         // Set after a write failed; the journal is no longer extended
+}
+
+// checkpointHeader identifies the file version a checkpoint belongs to
+type checkpointHeader struct {
+	File      string    `json:"file"`
+	Hash      string    `json:"hash"` // SHA-256 of the file contents
+	StartedAt time.Time `json:"started_at"`
+}
+
+// checkpointEntry is a committed row
+type checkpointEntry struct {
+	Row    model.RowResult `json:"row"`
+	Parsed bool            `json:"parsed"` // False for records the CSV reader rejected
+
+	// Tracks written by this replace row, see fileState.replacedTracks
+	ReplacedTracks map[int][]model.MagentoTrack `json:"replaced_tracks,omitempty"`
+
+	// Bulk operations of a row journaled while pending
+	Bulk *checkpointBulk `json:"bulk,omitempty"`
+
+	// Index of the earlier entry this one supersedes, for pending rows whose
+	// bulk operations finished
+	Updates *int `json:"updates,omitempty"`
+}
+
+// checkpointBulk holds what is needed to finish a row whose tracks were
+// submitted in bulk, once its operations completed
+type checkpointBulk struct {
+	TrackingInfo model.TrackingInfo    `json:"tracking_info"`
+	Order        model.MagentoOrder    `json:"order"`
+	Operations   []checkpointOperation `json:"operations"`
+}
+
+// checkpointOperation is a track submitted in bulk
+type checkpointOperation struct {
+	BulkUUID  string             `json:"bulk_uuid"`
+	Operation int                `json:"operation"`
+	Track     model.MagentoTrack `json:"track"`
+	Status    int                `json:"status"`
+	Message   string             `json:"message,omitempty"`
+}
+
+// checkpointPath returns where the checkpoint of a file is stored: in the
+// checkpoint directory when one is configured, else hidden next to the file.
+// In the checkpoint directory the name carries a hash of the file's absolute
+// path, as files of the same name may arrive in several watched directories.
+func (p *CSVProcessor) checkpointPath(filePath string) string {
+	dir := p.config.Load().FileWatch.CheckpointDir
+	if dir == "" {
+		return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+checkpointSuffix)
+	}
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		absPath = filepath.Clean(filePath)
+	}
+	sum := sha256.Sum256([]byte(absPath))
+	name := filepath.Base(filePath) + "." + hex.EncodeToString(sum[:6]) + checkpointSuffix
+	return filepath.Join(dir, name)
+}
+
+// openCheckpoint opens the checkpoint of a file. When a checkpoint for the
+// same file contents exists, its rows are skipped in the reader and restored
+// into the result and file state; otherwise a new checkpoint is started.
+func (p *CSVProcessor) openCheckpoint(reader *csv.Reader, state *fileState, result *model.FileResult, rowCount, errorCount *int) (*checkpoint, error) {
+	hash, err := hashFile(state.path)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &checkpoint{path: p.checkpointPath(state.path), pending: make(map[int]bool)}
+	header, entries, err := readCheckpoint(cp.path)
+	if err != nil {
+		p.logger.WithError(err).WithField("file", state.path).Warn("Ignoring unreadable checkpoint")
+	}
+
+	if header != nil && header.Hash != hash {
+		p.logger.WithField("file", state.path).Warn("File changed since its checkpoint was written, processing from the start")
+		header, entries = nil, nil
+	}
+
+	if header == nil {
+		header = &checkpointHeader{File: state.path, Hash: hash, StartedAt: result.StartedAt}
+		if err := cp.create(header); err != nil {
+			return nil, err
+		}
+		return cp, nil
+	}
+
+	// Skip and restore the committed rows
+	for i, entry := range entries {
+		if _, err := reader.Read(); err == io.EOF {
+			return nil, fmt.Errorf("checkpoint covers %d rows but the file has fewer", len(entries))
+		}
+		result.Rows = append(result.Rows, entry.Row)
+		if entry.Row.Outcome == model.OutcomePending && entry.Bulk != nil {
+			p.restoreBulkRow(state, i, entry.Bulk)
+			cp.pending[i] = true
+		}
+		if entry.Parsed {
+			*rowCount++
+		}
+		if entry.Row.Outcome == model.OutcomeFailed {
+			*errorCount++
+		}
+		for shipmentID, tracks := range entry.ReplacedTracks {
+			state.replacedTracks[shipmentID] = tracks
+		}
+	}
+	result.StartedAt = header.StartedAt
+	cp.committed = len(entries)
+	state.restored = len(entries)
+
+	// Rewrite the journal, dropping a line torn by a crash
+	if err := cp.create(header); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if err := cp.append(entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := cp.file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"file":           state.path,
+		"rows_completed": len(entries),
+	}).Info("Resuming file from checkpoint")
+	return cp, nil
+}
+
+// readCheckpoint reads a checkpoint; a missing checkpoint yields no header.
+// Entries superseding an earlier one replace it. An incomplete last line,
+// left by a crash while writing, is ignored.
+func readCheckpoint(path string) (*checkpointHeader, []checkpointEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return nil, nil, scanner.Err()
+	}
+	var header checkpointHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse checkpoint header: %w", err)
+	}
+
+	var entries []checkpointEntry
+	for scanner.Scan() {
+		var entry checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		if entry.Updates != nil {
+			if i := *entry.Updates; i >= 0 && i < len(entries) {
+				entry.Updates = nil
+				entries[i] = entry
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return &header, entries, nil
+}
+
+// hashFile returns the hex SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for hashing: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// create starts the journal with its header, replacing any previous one
+func (c *checkpoint) create(header *checkpointHeader) error {
+	f, err := os.Create(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	c.file = f
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint header: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return f.Sync()
+}
+
+// append writes an entry without syncing it
+func (c *checkpoint) append(entry checkpointEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint entry: %w", err)
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// commit journals the rows completed since the last commit. Rows queued for
+// bulk submission are journaled once Magento accepted their bulk, with the
+// bulk's UUID, and again once their operations finished. Until then commits
+// stop at the first pending row unless final is set, after the bulk
+// submission. It returns an error only for the first failed write; the
+// journal is not extended after that.
+func (c *checkpoint) commit(state *fileState, result *model.FileResult, final bool) error {
+	if c == nil || c.failed {
+		return nil
+	}
+
+	written := false
+	for i := range c.pending {
+		if result.Rows[i].Outcome == model.OutcomePending {
+			continue
+		}
+		entry := c.entry(state, result, i)
+		entry.Updates = &i
+		if err := c.append(entry); err != nil {
+			c.failed = true
+			return err
+		}
+		delete(c.pending, i)
+		written = true
+	}
+
+	for c.committed < len(result.Rows) {
+		row := result.Rows[c.committed]
+		if row.Outcome == model.OutcomePending && !final && !state.bulkSubmitted(c.committed) {
+			break
+		}
+
+		entry := c.entry(state, result, c.committed)
+		if err := c.append(entry); err != nil {
+			c.failed = true
+			return err
+		}
+		if entry.Bulk != nil {
+			c.pending[c.committed] = true
+		}
+		c.committed++
+		written = true
+	}
+
+	if !written {
+		return nil
+	}
+	if err := c.file.Sync(); err != nil {
+		c.failed = true
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+	return nil
+}
+
+// entry returns the journal entry of a row
+func (c *checkpoint) entry(state *fileState, result *model.FileResult, i int) checkpointEntry {
+	row := result.Rows[i]
+	entry := checkpointEntry{Row: row, Parsed: !state.unparsed[i]}
+	if row.Action == model.ActionReplace {
+		entry.ReplacedTracks = make(map[int][]model.MagentoTrack)
+		for _, shipmentID := range row.ShipmentIDs {
+			entry.ReplacedTracks[shipmentID] = state.replacedTracks[shipmentID]
+		}
+	}
+
+	// Pending rows keep what is needed to poll their bulk after a restart
+	if tracks := state.bulkRows[i]; row.Outcome == model.OutcomePending && state.bulkSubmitted(i) {
+		entry.Bulk = &checkpointBulk{TrackingInfo: *tracks[0].trackingInfo, Order: *tracks[0].order}
+		for _, t := range tracks {
+			entry.Bulk.Operations = append(entry.Bulk.Operations, checkpointOperation{
+				BulkUUID:  t.bulkUUID,
+				Operation: t.operation,
+				Track:     t.track,
+				Status:    t.status,
+				Message:   t.message,
+			})
+		}
+	}
+	return entry
+}
+
+// close closes the journal, keeping it for the next start
+func (c *checkpoint) close() {
+	if c != nil && c.file != nil {
+		c.file.Close()
+	}
+}
+
+// removeCheckpoint deletes the checkpoint of a file once the file was
+// processed and its report written. Dry runs keep no checkpoint and leave
+// the one of an earlier run in place.
+func (p *CSVProcessor) removeCheckpoint(filePath string) {
+	if p.config.Load().DryRun.Enabled {
+		return
+	}
+	if err := os.Remove(p.checkpointPath(filePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		p.logger.WithError(err).WithField("file", filePath).Warn("Failed to remove checkpoint")
+	}
+}
@@ -0,0 +1,361 @@
+package processor
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestProcessor returns a processor with the given configuration that
+// logs nowhere and has a router without routes
+func newTestProcessor(cfg *config.Config) *CSVProcessor {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	p := &CSVProcessor{logger: logger, router: api.NewRouter(&cfg.Magento, logger)}
+	p.config.Store(cfg)
+	return p
+}
+
+func TestCheckpointPath(t *testing.T) {
+	tests := []struct {
+		name          string
+		checkpointDir string
+		file          string
+		wantDir       string
+		wantPrefix    string
+	}{
+		{"next to the file", "", "/data/in/tracking.csv", "/data/in", ".tracking.csv."},
+		{"next to a file in another directory", "", "/data/extra/tracking.csv", "/data/extra", ".tracking.csv."},
+		{"in checkpoint_dir", "/var/checkpoints", "/data/in/tracking.csv", "/var/checkpoints", "tracking.csv."},
+		{"in checkpoint_dir for another directory", "/var/checkpoints", "/data/extra/tracking.csv", "/var/checkpoints", "tracking.csv."},
+	}
+
+	seen := make(map[string]string)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProcessor(&config.Config{FileWatch: config.FileWatchConfig{CheckpointDir: tc.checkpointDir}})
+			path := p.checkpointPath(tc.file)
+
+			if dir := filepath.Dir(path); dir != tc.wantDir {
+				t.Errorf("checkpointPath(%q) = %q, want it in %q", tc.file, path, tc.wantDir)
+			}
+			name := filepath.Base(path)
+			if !strings.HasPrefix(name, tc.wantPrefix) || !strings.HasSuffix(name, checkpointSuffix) {
+				t.Errorf("checkpointPath(%q) = %q, want %s...%s", tc.file, path, tc.wantPrefix, checkpointSuffix)
+			}
+			if other, ok := seen[path]; ok {
+				t.Errorf("checkpointPath(%q) = %q, same as for %q", tc.file, path, other)
+			}
+			seen[path] = tc.file
+		})
+	}
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	header := `{"file":"tracking.csv","hash":"abc","started_at":"2024-01-02T03:04:05Z"}`
+	success := `{"row":{"line":2,"outcome":"success"},"parsed":true}`
+	failed := `{"row":{"line":3,"outcome":"failed"},"parsed":true}`
+	pending := `{"row":{"line":3,"outcome":"pending"},"parsed":true,"bulk":{"tracking_info":{},"order":{},"operations":[]}}`
+
+	tests := []struct {
+		name        string
+		contents    *string // nil for a missing checkpoint
+		wantHeader  bool
+		wantLines   []int
+		wantOutcome []string
+	}{
+		{"missing", nil, false, nil, nil},
+		{"header only", ptr(header + "\n"), true, nil, nil},
+		{"entries", ptr(header + "\n" + success + "\n" + failed + "\n"), true, []int{2, 3}, []string{"success", "failed"}},
+		{"torn last line", ptr(header + "\n" + success + "\n" + failed[:20]), true, []int{2}, []string{"success"}},
+		{"update replaces pending row", ptr(header + "\n" + success + "\n" + pending + "\n" + `{"row":{"line":3,"outcome":"success"},"parsed":true,"updates":1}` + "\n"), true, []int{2, 3}, []string{"success", "success"}},
+		{"update out of range", ptr(header + "\n" + success + "\n" + `{"row":{"line":9,"outcome":"failed"},"parsed":true,"updates":5}` + "\n"), true, []int{2}, []string{"success"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tracking.csv"+checkpointSuffix)
+			if tc.contents != nil {
+				if err := os.WriteFile(path, []byte(*tc.contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gotHeader, entries, err := readCheckpoint(path)
+			if err != nil {
+				t.Fatalf("readCheckpoint() error = %v", err)
+			}
+			if (gotHeader != nil) != tc.wantHeader {
+				t.Fatalf("readCheckpoint() header = %v, want header %v", gotHeader, tc.wantHeader)
+			}
+			if len(entries) != len(tc.wantLines) {
+				t.Fatalf("readCheckpoint() returned %d entries, want %d", len(entries), len(tc.wantLines))
+			}
+			for i, entry := range entries {
+				if entry.Row.Line != tc.wantLines[i] || entry.Row.Outcome != tc.wantOutcome[i] {
+					t.Errorf("entry %d = line %d %s, want line %d %s", i, entry.Row.Line, entry.Row.Outcome, tc.wantLines[i], tc.wantOutcome[i])
+				}
+				if entry.Updates != nil {
+					t.Errorf("entry %d still has updates = %d", i, *entry.Updates)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointCommit(t *testing.T) {
+	tests := []struct {
+		name          string
+		outcomes      []string
+		submitted     []int // Pending rows whose bulk Magento accepted
+		final         bool
+		wantCommitted int
+		wantPending   []int
+	}{
+		{"completed rows", []string{model.OutcomeSuccess, model.OutcomeFailed}, nil, false, 2, nil},
+		{"stops at queued row", []string{model.OutcomeSuccess, model.OutcomePending, model.OutcomeSuccess}, nil, false, 1, nil},
+		{"final commits queued row", []string{model.OutcomeSuccess, model.OutcomePending, model.OutcomeSuccess}, nil, true, 3, nil},
+		{"journals submitted row", []string{model.OutcomeSuccess, model.OutcomePending, model.OutcomeSuccess}, []int{1}, false, 3, []int{1}},
+		{"final journals submitted row", []string{model.OutcomePending, model.OutcomePending}, []int{0}, true, 2, []int{0}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state, result := newCheckpointTestFile(tc.outcomes, tc.submitted)
+			cp := newTestCheckpoint(t)
+			defer cp.close()
+
+			if err := cp.commit(state, result, tc.final); err != nil {
+				t.Fatalf("commit() error = %v", err)
+			}
+			if cp.committed != tc.wantCommitted {
+				t.Errorf("committed = %d, want %d", cp.committed, tc.wantCommitted)
+			}
+			if len(cp.pending) != len(tc.wantPending) {
+				t.Errorf("pending = %v, want %v", cp.pending, tc.wantPending)
+			}
+			for _, row := range tc.wantPending {
+				if !cp.pending[row] {
+					t.Errorf("row %d not pending, want pending", row)
+				}
+			}
+
+			_, entries, err := readCheckpoint(cp.path)
+			if err != nil {
+				t.Fatalf("readCheckpoint() error = %v", err)
+			}
+			if len(entries) != tc.wantCommitted {
+				t.Fatalf("journal has %d entries, want %d", len(entries), tc.wantCommitted)
+			}
+			for i, entry := range entries {
+				if wantBulk := cp.pending[i]; (entry.Bulk != nil) != wantBulk {
+					t.Errorf("entry %d bulk = %v, want bulk %v", i, entry.Bulk, wantBulk)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointCommitUpdatesFinishedBulkRow(t *testing.T) {
+	state, result := newCheckpointTestFile([]string{model.OutcomeSuccess, model.OutcomePending}, []int{1})
+	cp := newTestCheckpoint(t)
+	defer cp.close()
+
+	if err := cp.commit(state, result, false); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+
+	// Still open, nothing to update
+	if err := cp.commit(state, result, true); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+	if !cp.pending[1] {
+		t.Fatal("row 1 no longer pending while its operations are open")
+	}
+
+	result.Rows[1].Outcome = model.OutcomeSuccess
+	if err := cp.commit(state, result, true); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+	if len(cp.pending) != 0 {
+		t.Errorf("pending = %v after the bulk row finished, want none", cp.pending)
+	}
+
+	_, entries, err := readCheckpoint(cp.path)
+	if err != nil {
+		t.Fatalf("readCheckpoint() error = %v", err)
+	}
+	if len(entries) != 2 || entries[1].Row.Outcome != model.OutcomeSuccess || entries[1].Bulk != nil {
+		t.Errorf("journal entries = %+v, want row 1 replaced by its success", entries)
+	}
+}
+
+func TestOpenCheckpointResume(t *testing.T) {
+	csvData := "order_number,tracking_number,carrier_code,title\n" +
+		"000000001,1Z999AA10123456784,ups,UPS\n" +
+		"000000002,1Z999AA10123456784,ups,UPS\n" +
+		"000000003,1Z999AA10123456784,ups,UPS\n"
+
+	tests := []struct {
+		name         string
+		outcomes     []string // Rows committed before the restart
+		submitted    []int
+		torn         bool // Append half an entry, as a crash while writing would
+		changed      bool // Change the file after the checkpoint was written
+		wantRows     int
+		wantErrors   int
+		wantNext     string // Order number of the next row read
+		wantBulkRows []int
+	}{
+		{"no checkpoint", nil, nil, false, false, 0, 0, "000000001", nil},
+		{"resumes after committed rows", []string{model.OutcomeSuccess, model.OutcomeFailed}, nil, false, false, 2, 1, "000000003", nil},
+		{"ignores torn last line", []string{model.OutcomeSuccess}, nil, true, false, 1, 0, "000000002", nil},
+		{"restarts changed file", []string{model.OutcomeSuccess, model.OutcomeSuccess}, nil, false, true, 0, 0, "000000001", nil},
+		{"restores submitted bulk row", []string{model.OutcomeSuccess, model.OutcomePending}, []int{1}, false, false, 2, 0, "000000003", []int{1}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tracking.csv")
+			if err := os.WriteFile(path, []byte(csvData), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p := newTestProcessor(&config.Config{})
+
+			// First run, committing the given rows
+			if tc.outcomes != nil {
+				state, result := newCheckpointTestFile(tc.outcomes, tc.submitted)
+				state.path = path
+				cp, err := p.openCheckpoint(openTestCSV(t, path), state, &model.FileResult{}, new(int), new(int))
+				if err != nil {
+					t.Fatalf("openCheckpoint() error = %v", err)
+				}
+				if err := cp.commit(state, result, false); err != nil {
+					t.Fatalf("commit() error = %v", err)
+				}
+				cp.close()
+			}
+			if tc.torn {
+				appendFile(t, p.checkpointPath(path), `{"row":{"line":3,"outc`)
+			}
+			if tc.changed {
+				appendFile(t, path, "000000004,1Z999AA10123456784,ups,UPS\n")
+			}
+
+			// Restart
+			reader := openTestCSV(t, path)
+			state := newFileState(path, &config.TrackingConfig{}, nil)
+			result := &model.FileResult{}
+			rowCount, errorCount := 0, 0
+			cp, err := p.openCheckpoint(reader, state, result, &rowCount, &errorCount)
+			if err != nil {
+				t.Fatalf("openCheckpoint() error = %v", err)
+			}
+			defer cp.close()
+
+			if len(result.Rows) != tc.wantRows || rowCount != tc.wantRows || state.restored != tc.wantRows || cp.committed != tc.wantRows {
+				t.Errorf("restored rows = %d, row count %d, state %d, committed %d, want %d", len(result.Rows), rowCount, state.restored, cp.committed, tc.wantRows)
+			}
+			if errorCount != tc.wantErrors {
+				t.Errorf("error count = %d, want %d", errorCount, tc.wantErrors)
+			}
+
+			record, err := reader.Read()
+			if err != nil {
+				t.Fatalf("reading next row: %v", err)
+			}
+			if record[0] != tc.wantNext {
+				t.Errorf("next row = %s, want %s", record[0], tc.wantNext)
+			}
+
+			if len(state.bulkRows) != len(tc.wantBulkRows) {
+				t.Errorf("bulk rows = %v, want %v", state.bulkRows, tc.wantBulkRows)
+			}
+			for _, row := range tc.wantBulkRows {
+				if !state.bulkSubmitted(row) || !cp.pending[row] {
+					t.Errorf("row %d not restored as submitted bulk row", row)
+				}
+			}
+
+			// The journal was rewritten without the torn line
+			_, entries, err := readCheckpoint(cp.path)
+			if err != nil || len(entries) != tc.wantRows {
+				t.Errorf("rewritten journal has %d entries (error %v), want %d", len(entries), err, tc.wantRows)
+			}
+		})
+	}
+}
+
+// newCheckpointTestFile returns the state and result of a file with rows of
+// the given outcomes. Submitted rows get a track accepted in bulk.
+func newCheckpointTestFile(outcomes []string, submitted []int) (*fileState, *model.FileResult) {
+	state := newFileState("tracking.csv", &config.TrackingConfig{}, nil)
+	result := &model.FileResult{}
+	for i, outcome := range outcomes {
+		result.Rows = append(result.Rows, model.RowResult{Line: i + 2, Outcome: outcome})
+	}
+	for _, row := range submitted {
+		state.addBulkTrack(&bulkTrack{
+			row:          row,
+			trackingInfo: &model.TrackingInfo{OrderNumber: "000000002"},
+			order:        &model.MagentoOrder{IncrementID: "000000002"},
+			bulkUUID:     "bulk-1",
+			operation:    row,
+			status:       model.BulkOperationOpen,
+		})
+	}
+	return state, result
+}
+
+// newTestCheckpoint returns an empty checkpoint in a temporary directory
+func newTestCheckpoint(t *testing.T) *checkpoint {
+	t.Helper()
+	cp := &checkpoint{path: filepath.Join(t.TempDir(), "tracking.csv"+checkpointSuffix), pending: make(map[int]bool)}
+	if err := cp.create(&checkpointHeader{File: "tracking.csv"}); err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+// openTestCSV opens a CSV file and reads its header
+func openTestCSV(t *testing.T, path string) *csv.Reader {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	reader := csv.NewReader(f)
+	if _, err := reader.Read(); err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+// appendFile appends data to a file
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
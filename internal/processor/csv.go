@@ -1,40 +1,225 @@
 package processor
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"tracking-updater/config"
 	"tracking-updater/internal/api"
+	"tracking-updater/internal/metrics"
 	"tracking-updater/internal/model"
+	"tracking-updater/internal/tracing"
 )
 
 // CSVProcessor handles processing of CSV files
 type CSVProcessor struct {
-	config         *config.Config
+	config         atomic.Pointer[config.Config] // Replaced on configuration reloads
 	logger         *logrus.Logger
-	magentoClient  *api.MagentoClient
+	router         *api.Router
 	workChan       chan string
 	wg             sync.WaitGroup
 	processedFiles map[string]bool
 	mutex          sync.Mutex
+	commentTmpl    atomic.Pointer[template.Template]
+	carriers       *carrierRegistry
+	carrierAliases atomic.Pointer[model.CarrierNormalizer]
+	stopChan       chan struct{}
+	interruptChan  chan struct{} // Closed when the shutdown grace period expired
+	stopping       bool          // Set once Stop began; guarded by mutex
+	activeWorkers  atomic.Int32
+
+	// Worker pool size, guarded by mutex. Workers exit while more run than
+	// configured; resized is closed and replaced to wake idle workers.
+	workers      int
+	workerTarget int
+	nextWorkerID int
+	resized      chan struct{}
+}
+
+// fileState holds per-file state shared by the rows of a file
+type fileState struct {
+	path string
+
+	// row is the index in FileResult.Rows of the row being processed
+	row int
+
+	// replacedTracks holds, per shipment, the tracks written by replace rows
+	// so far, so later replace rows for the same shipment keep them
+	replacedTracks map[int][]model.MagentoTrack
+
+	// itemGroups holds item rows grouped by order and tracking number, in
+	// order of first appearance
+	itemGroups     []*itemGroup
+	itemGroupIndex map[string]*itemGroup
+
+	// bulkTracks holds the tracks queued for bulk submission, bulkRows the
+	// same tracks by index in FileResult.Rows
+	bulkTracks []*bulkTrack
+	bulkRows   map[int][]*bulkTrack
+
+	// interrupted is set when processing stopped early for shutdown
+	interrupted bool
+
+	// unparsed marks the rows the CSV reader rejected, by index in
+	// FileResult.Rows, as they do not count towards the row count
+	unparsed map[int]bool
+
+	// restored is the number of rows restored from the checkpoint
+	restored int
+
+	// tracking holds the tracking settings; carrierAliases maps free-text
+	// carrier names to Magento carrier codes and unmappedCarriers counts the
+	// values it did not recognize
+	tracking         *config.TrackingConfig
+	carrierAliases   *model.CarrierNormalizer
+	unmappedCarriers map[string]int
+}
+
+// newFileState creates the state for processing a file
+func newFileState(path string, tracking *config.TrackingConfig, carrierAliases *model.CarrierNormalizer) *fileState {
+	return &fileState{
+		path:             path,
+		replacedTracks:   make(map[int][]model.MagentoTrack),
+		itemGroupIndex:   make(map[string]*itemGroup),
+		bulkRows:         make(map[int][]*bulkTrack),
+		unparsed:         make(map[int]bool),
+		tracking:         tracking,
+		carrierAliases:   carrierAliases,
+		unmappedCarriers: make(map[string]int),
+	}
+}
+
+// resolveCarrier maps the row's carrier to its Magento carrier code and
+// title through the configured aliases, counting values no alias matches,
+// and then checks it against the carrier detected from the tracking number.
+// Deleting only needs the tracking number, so delete rows skip detection.
+func (f *fileState) resolveCarrier(trackingInfo *model.TrackingInfo, result *model.RowResult) {
+	if f.carrierAliases.Enabled() {
+		original := strings.TrimSpace(trackingInfo.CarrierCode)
+		if original == "" {
+			original = strings.TrimSpace(trackingInfo.Title)
+		}
+		if !f.carrierAliases.Normalize(trackingInfo) {
+			f.unmappedCarriers[original]++
+		}
+	}
+
+	if f.tracking.DetectCarrier && trackingInfo.Action != model.ActionDelete {
+		f.applyDetectedCarrier(trackingInfo, result)
+	}
+	result.CarrierCode = trackingInfo.CarrierCode
+}
+
+// applyDetectedCarrier fills in a missing carrier from the tracking number
+// format and flags rows whose carrier differs from the detected one
+func (f *fileState) applyDetectedCarrier(trackingInfo *model.TrackingInfo, result *model.RowResult) {
+	detected, ok := model.DetectCarrier(trackingInfo.TrackingNumber)
+	if !ok {
+		return
+	}
+	result.DetectedCarrier = detected.Code
+
+	if strings.TrimSpace(trackingInfo.CarrierCode) == "" {
+		trackingInfo.CarrierCode = detected.Code
+		trackingInfo.Title = detected.Title
+		return
+	}
+	if !detected.Matches(trackingInfo.CarrierCode, trackingInfo.Title) {
+		result.CarrierMismatch = true
+		return
+	}
+	if strings.TrimSpace(trackingInfo.Title) == "" {
+		trackingInfo.Title = detected.Title
+	}
+}
+
+// validate validates the row's tracking information. Tracking numbers that
+// fail the checks of their carrier fail the row, are recorded as a warning or
+// are ignored, depending on tracking.tracking_number_check.
+func (f *fileState) validate(trackingInfo *model.TrackingInfo, result *model.RowResult) error {
+	err := trackingInfo.Validate()
+	var numberErr *model.TrackingNumberError
+	if err == nil || !errors.As(err, &numberErr) {
+		return err
+	}
+
+	switch f.tracking.TrackingNumberCheck {
+	case model.TrackingNumberCheckReject:
+		return err
+	case model.TrackingNumberCheckWarn:
+		result.TrackingNumberWarning = err.Error()
+	}
+	return nil
+}
+
+// commentData is the data available to the shipment comment template
+type commentData struct {
+	OrderNumber    string
+	TrackingNumber string
+	CarrierCode    string
+	Title          string
+	File           string
+	ShipmentID     int
 }
 
 // NewCSVProcessor creates a new CSV processor
-func NewCSVProcessor(cfg *config.Config, logger *logrus.Logger, magentoClient *api.MagentoClient) *CSVProcessor {
-	return &CSVProcessor{
-		config:         cfg,
+func NewCSVProcessor(cfg *config.Config, logger *logrus.Logger, router *api.Router) *CSVProcessor {
+	p := &CSVProcessor{
 		logger:         logger,
-		magentoClient:  magentoClient,
+		router:         router,
 		workChan:       make(chan string, 100),
 		processedFiles: make(map[string]bool),
+		carriers:       newCarrierRegistry(&cfg.Tracking.Carriers, logger),
+		stopChan:       make(chan struct{}),
+		interruptChan:  make(chan struct{}),
+		resized:        make(chan struct{}),
 	}
+	p.applyConfig(cfg)
+
+	// Dry runs must not change Magento on any path, recorded or not
+	router.SetDryRun(cfg.DryRun.Enabled)
+
+	return p
+}
+
+// Reconfigure applies a reloaded configuration. Tracking settings apply from
+// the next row on, and workers are started or retired to match the worker
+// count; busy workers retire once their file is complete.
+func (p *CSVProcessor) Reconfigure(cfg *config.Config) {
+	p.applyConfig(cfg)
+	p.resizeWorkers(cfg.FileWatch.MaxConcurrency)
+}
+
+// applyConfig stores the configuration and the state derived from it
+func (p *CSVProcessor) applyConfig(cfg *config.Config) {
+	var commentTmpl *template.Template
+	if cfg.Tracking.Comment.Enabled {
+		tmpl, err := template.New("comment").Parse(cfg.Tracking.Comment.Template)
+		if err != nil {
+			p.logger.WithError(err).Error("Invalid shipment comment template, shipment comments disabled")
+		} else {
+			commentTmpl = tmpl
+		}
+	}
+
+	p.config.Store(cfg)
+	p.commentTmpl.Store(commentTmpl)
+	p.carrierAliases.Store(newCarrierNormalizer(cfg.Tracking.CarrierAliases))
 }
 
 // Start begins processing files
@@ -42,85 +227,337 @@ func (p *CSVProcessor) Start() {
 	p.logger.Info("Starting CSV processor")
 	
 	// Create the processed and failed directories if they don't exist
-	if err := os.MkdirAll(p.config.FileWatch.ProcessedDir, 0755); err != nil {
+	if err := os.MkdirAll(p.config.Load().FileWatch.ProcessedDir, 0755); err != nil {
 		p.logger.WithError(err).Error("Failed to create processed directory")
 	}
 	
-	if err := os.MkdirAll(p.config.FileWatch.FailedDir, 0755); err != nil {
+	if err := os.MkdirAll(p.config.Load().FileWatch.FailedDir, 0755); err != nil {
 		p.logger.WithError(err).Error("Failed to create failed directory")
 	}
 
+	if p.config.Load().FileWatch.ReportDir != "" {
+		if err := os.MkdirAll(p.config.Load().FileWatch.ReportDir, 0755); err != nil {
+			p.logger.WithError(err).Error("Failed to create report directory")
+		}
+	}
+
+	if p.config.Load().DryRun.Enabled {
+		p.logger.Warn("Dry run: changes are recorded in the reports instead of being sent to Magento, and files stay in place")
+		if dir := p.config.Load().DryRun.CopyDir; dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				p.logger.WithError(err).Error("Failed to create dry run copy directory")
+			}
+		}
+	}
+
+	// Load the carriers known to Magento and keep them current
+	if p.config.Load().Tracking.Carriers.Validate {
+		p.carriers.refresh(context.Background(), p.router.Clients())
+		go p.refreshCarriers()
+	}
+
 	// Start worker goroutines
-	for i := 0; i < p.config.FileWatch.MaxConcurrency; i++ {
+	p.resizeWorkers(p.config.Load().FileWatch.MaxConcurrency)
+}
+
+// resizeWorkers starts workers, or asks surplus ones to exit, until the
+// given number of workers runs
+func (p *CSVProcessor) resizeWorkers(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stopping || n == p.workerTarget {
+		return
+	}
+	if p.workerTarget > 0 {
+		p.logger.WithFields(logrus.Fields{
+			"from": p.workerTarget,
+			"to":   n,
+		}).Info("Resizing worker pool")
+	}
+
+	p.workerTarget = n
+	for p.workers < n {
+		p.workers++
 		p.wg.Add(1)
-		go p.worker(i)
+		p.activeWorkers.Add(1) // Counted before it runs, see WorkersAlive
+		go p.worker(p.nextWorkerID, p.resized)
+		p.nextWorkerID++
+	}
+	metrics.Workers.Set(float64(n))
+
+	// Wake idle workers so surplus ones exit
+	close(p.resized)
+	p.resized = make(chan struct{})
+}
+
+// retire reports whether the calling worker should exit because more
+// workers run than configured, and counts it out if so. Otherwise it returns
+// the current resize signal to wait on.
+func (p *CSVProcessor) retire() (bool, chan struct{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.workers <= p.workerTarget {
+		return false, p.resized
+	}
+	p.workers--
+	return true, nil
+}
+
+// nextFile waits for the next file to process. It reports false when the
+// worker should exit, as the processor stopped or the pool shrank. resized is
+// the resize signal the worker saw last; when the pool was resized while the
+// worker was busy, it is already closed and retirement is checked right away.
+func (p *CSVProcessor) nextFile(resized *chan struct{}) (string, bool) {
+	for {
+		select {
+		case filePath := <-p.workChan:
+			return filePath, true
+		case <-p.stopChan:
+			return "", false
+		case <-*resized:
+			retired, current := p.retire()
+			if retired {
+				return "", false
+			}
+			*resized = current
+		}
 	}
 }
 
-// Stop stops the processor
+// Stop stops the processor gracefully. No new files are accepted and queued
+// files that were not started are left for the next start. Files in progress
+// may complete within the shutdown grace period; after that they stop once
+// their current row finished, and the next start resumes them from their
+// checkpoint after the last committed row.
 func (p *CSVProcessor) Stop() {
-	p.logger.Info("Stopping CSV processor")
-	close(p.workChan)
-	p.wg.Wait()
+	grace := p.config.Load().Shutdown.GracePeriod
+	p.logger.WithField("grace_period", grace).Info("Stopping CSV processor")
+
+	close(p.stopChan)
+	p.mutex.Lock()
+	p.stopping = true
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		p.logger.Warn("Shutdown grace period expired, interrupting files in progress")
+		close(p.interruptChan)
+		<-done
+	}
+}
+
+// stopped reports whether Stop was called
+func (p *CSVProcessor) stopped() bool {
+	select {
+	case <-p.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupted reports whether files in progress must stop at the next row,
+// as the shutdown grace period expired or the file's context was cancelled
+func (p *CSVProcessor) interrupted(ctx context.Context) bool {
+	select {
+	case <-p.interruptChan:
+		return true
+	default:
+		return ctx.Err() != nil
+	}
 }
 
-// ProcessFile queues a file for processing
+// refreshCarriers periodically reloads the carriers known to Magento
+func (p *CSVProcessor) refreshCarriers() {
+	interval := p.config.Load().Tracking.Carriers.RefreshInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.carriers.refresh(context.Background(), p.router.Clients())
+		}
+	}
+}
+
+// ProcessFile queues a file for processing. It blocks while the queue is full.
 func (p *CSVProcessor) ProcessFile(filePath string) {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
+	if p.stopping {
+		p.mutex.Unlock()
+		p.logger.WithField("file", filePath).Info("Processor stopping, leaving file for the next start")
+		return
+	}
 
 	// Check if the file has already been processed
 	if p.processedFiles[filePath] {
+		p.mutex.Unlock()
 		p.logger.WithField("file", filePath).Info("File already processed, skipping")
 		return
 	}
-
 	p.processedFiles[filePath] = true
-	p.workChan <- filePath
+	p.mutex.Unlock()
+
+	// Queue without holding the mutex, which workers need to retire
+	metrics.QueueDepth.Inc()
+	select {
+	case p.workChan <- filePath:
+	case <-p.stopChan:
+		metrics.QueueDepth.Dec()
+		p.logger.WithField("file", filePath).Info("Processor stopping, leaving file for the next start")
+	}
 }
 
-// worker processes files from the work channel
-func (p *CSVProcessor) worker(id int) {
+// ProcessOnce processes a file right away, without queueing, moving it or
+// writing its report, for one-shot runs. When lines is not nil, only the rows
+// starting on those lines are processed. It returns the result, nil when the
+// file could not be read, and whether the file succeeded.
+func (p *CSVProcessor) ProcessOnce(ctx context.Context, filePath string, lines map[int]bool) (*model.FileResult, bool) {
+	ctx, span := tracing.Tracer().Start(ctx, "process file", trace.WithAttributes(attribute.String("file", filePath)))
+	defer span.End()
+
+	result, success, _ := p.processCSVFile(ctx, filePath, lines)
+	if result != nil && lines == nil {
+		p.removeCheckpoint(filePath)
+	}
+	if !success {
+		span.SetStatus(codes.Error, "file failed")
+	}
+	return result, success
+}
+
+// WorkersAlive reports an error when fewer workers run than configured
+func (p *CSVProcessor) WorkersAlive(ctx context.Context) error {
+	p.mutex.Lock()
+	target := p.workerTarget
+	p.mutex.Unlock()
+
+	active := int(p.activeWorkers.Load())
+	if active < target {
+		return fmt.Errorf("only %d of %d workers running", active, target)
+	}
+	return nil
+}
+
+// worker processes files from the work channel. resized is the resize signal
+// current when the worker was started.
+func (p *CSVProcessor) worker(id int, resized chan struct{}) {
 	defer p.wg.Done()
+	defer p.activeWorkers.Add(-1)
 
 	log := p.logger.WithField("worker_id", id)
 	log.Info("Starting worker")
 
-	for filePath := range p.workChan {
-		log := log.WithField("file", filePath)
+	for {
+		filePath, ok := p.nextFile(&resized)
+		if !ok {
+			break
+		}
+		metrics.QueueDepth.Dec()
+		if p.stopped() {
+			log.WithField("file", filePath).Info("Processor stopping, leaving file for the next start")
+			continue
+		}
+
+		ctx, span := tracing.Tracer().Start(context.Background(), "process file", trace.WithAttributes(
+			attribute.String("file", filePath),
+			attribute.Int("worker_id", id),
+		))
+		log := log.WithContext(ctx).WithField("file", filePath)
 		log.Info("Processing file")
 
-		success := p.processCSVFile(filePath)
+		metrics.WorkersBusy.Inc()
+		busyStart := time.Now()
+		result, success, interrupted := p.processCSVFile(ctx, filePath, nil)
+		metrics.WorkerBusySeconds.Add(time.Since(busyStart).Seconds())
+		metrics.WorkersBusy.Dec()
+
+		// Interrupted files stay in place and are resumed on the next start
+		if interrupted {
+			metrics.FilesProcessed.WithLabelValues(metrics.DispositionInterrupted).Inc()
+			log.Info("File interrupted by shutdown, leaving it for the next start")
+			span.SetAttributes(attribute.Bool("interrupted", true))
+			span.End()
+			continue
+		}
+		if result != nil {
+			p.writeReport(result)
+		}
+
+		if success {
+			metrics.FilesProcessed.WithLabelValues(metrics.DispositionProcessed).Inc()
+			metrics.LastSuccessfulFile.SetToCurrentTime()
+		} else {
+			metrics.FilesProcessed.WithLabelValues(metrics.DispositionFailed).Inc()
+		}
 		
+		// Dry runs leave the file in place, copying it if configured
+		if dryRun := p.config.Load().DryRun; dryRun.Enabled {
+			if dryRun.CopyDir != "" {
+				p.copyFile(log, filePath, dryRun.CopyDir)
+			}
+			span.SetAttributes(attribute.Bool("dry_run", true))
+			span.End()
+			continue
+		}
+
 		// Move the file to the appropriate directory
-		destinationDir := p.config.FileWatch.ProcessedDir
+		destinationDir := p.config.Load().FileWatch.ProcessedDir
 		if !success {
-			destinationDir = p.config.FileWatch.FailedDir
+			destinationDir = p.config.Load().FileWatch.FailedDir
 		}
 
-		fileName := filepath.Base(filePath)
+		fileName := p.config.Load().FileWatch.ArchiveName(filePath)
 		destinationPath := filepath.Join(destinationDir, fileName)
 		
+		// The checkpoint goes last, so a crash before the file was moved
+		// resumes it rather than applying every row again
 		if err := os.Rename(filePath, destinationPath); err != nil {
 			log.WithError(err).Error("Failed to move file")
 		} else {
 			log.WithField("destination", destinationPath).Info("Moved file")
+			p.removeCheckpoint(filePath)
 		}
+
+		span.SetAttributes(attribute.String("destination", destinationDir))
+		if !success {
+			span.SetStatus(codes.Error, "file failed")
+		}
+		span.End()
 	}
 
 	log.Info("Worker stopped")
 }
 
-// processCSVFile processes a single CSV file
-func (p *CSVProcessor) processCSVFile(filePath string) bool {
-	log := p.logger.WithField("file", filePath)
+// processCSVFile processes a single CSV file. When lines is not nil, only the
+// rows starting on those lines are processed. It returns the result, nil when
+// the file could not be read or was interrupted, whether the file succeeded,
+// and whether it was interrupted by shutdown before completing.
+func (p *CSVProcessor) processCSVFile(ctx context.Context, filePath string, lines map[int]bool) (result *model.FileResult, success bool, interrupted bool) {
+	log := p.logger.WithContext(ctx).WithField("file", filePath)
 	startTime := time.Now()
 
 	// Open the file
 	file, err := os.Open(filePath)
 	if err != nil {
 		log.WithError(err).Error("Failed to open file")
-		return false
+		return nil, false, false
 	}
 	defer file.Close()
 
@@ -131,50 +568,227 @@ func (p *CSVProcessor) processCSVFile(filePath string) bool {
 	header, err := reader.Read()
 	if err != nil {
 		log.WithError(err).Error("Failed to read CSV header")
-		return false
+		return nil, false, false
 	}
 
 	// Check if the CSV has the required columns
 	indices := getColumnIndices(header)
 	if indices.orderNumber == -1 || indices.trackingNumber == -1 || indices.carrierCode == -1 || indices.title == -1 {
 		log.Error("CSV file does not have required columns")
-		return false
+		return nil, false, false
+	}
+
+	// Item rows ship order items, so their lookups include them
+	if indices.isItemFormat() {
+		ctx = api.WithOrderItems(ctx)
 	}
 
+	// Resolve all orders of the file up front, so rows hit the lookup cache
+	p.prefetchOrders(ctx, filePath, indices)
+
 	// Process each row
 	rowCount := 0
 	errorCount := 0
+	dryRun := p.config.Load().DryRun.Enabled
+	result = &model.FileResult{File: filePath, StartedAt: startTime, DryRun: dryRun}
+	state := newFileState(filePath, &p.config.Load().Tracking, p.carrierAliases.Load())
+
+	// Resume after the rows committed before the last shutdown or crash. Item
+	// rows only change Magento once all are read, and boxes already shipped
+	// are skipped when the file is processed again, so they need no checkpoint.
+	// Neither do runs of selected lines, nor dry runs, whose checkpoint would
+	// make the real run skip rows.
+	var cp *checkpoint
+	if !indices.isItemFormat() && lines == nil && !dryRun {
+		cp, err = p.openCheckpoint(reader, state, result, &rowCount, &errorCount)
+		if err != nil {
+			log.WithError(err).Error("Failed to open checkpoint")
+			return nil, false, false
+		}
+		defer cp.close()
+	}
+	commit := func(final bool) {
+		if err := cp.commit(state, result, final); err != nil {
+			log.WithError(err).Error("Failed to write checkpoint, a restart will process the file from its last committed row")
+		}
+	}
 
 	for {
+		// Stop between rows once the shutdown grace period expired
+		if p.interrupted(ctx) {
+			state.interrupted = true
+			break
+		}
+
 		row, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			log.WithError(err).Error("Failed to read CSV row")
+			rowResult := model.RowResult{Outcome: model.OutcomeFailed, Error: err.Error()}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowResult.Line = parseErr.Line
+			}
+			if lines != nil && !lines[rowResult.Line] {
+				continue
+			}
+			state.unparsed[len(result.Rows)] = true
+			result.Rows = append(result.Rows, rowResult)
 			errorCount++
+			commit(false)
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		if lines != nil && !lines[line] {
+			continue
+		}
+		rowResult := model.RowResult{Line: line, Outcome: model.OutcomeSuccess}
+
+		// Item rows are only collected here and shipped per group below
+		if indices.isItemFormat() {
+			if err := state.collectItemRow(row, indices, &rowResult, len(result.Rows)); err != nil {
+				log.WithError(err).WithField("line", line).Warn("Failed to process row")
+				rowResult.Outcome = model.OutcomeFailed
+				rowResult.Error = err.Error()
+				errorCount++
+			}
+			result.Rows = append(result.Rows, rowResult)
+			rowCount++
 			continue
 		}
 
 		// Process the row
-		if err := p.processRow(row, indices); err != nil {
-			log.WithError(err).Warn("Failed to process row")
+		state.row = len(result.Rows)
+		rowCtx, span := tracing.Tracer().Start(ctx, "process row", trace.WithAttributes(attribute.Int("line", line)))
+		var recorder *api.Recorder
+		if dryRun {
+			recorder = &api.Recorder{}
+			rowCtx = api.WithRecorder(rowCtx, recorder)
+		}
+		err = p.processRow(rowCtx, state, row, indices, &rowResult)
+		if recorder != nil {
+			rowResult.WouldPost = recorder.Requests()
+		}
+		if err != nil && ctx.Err() != nil {
+			// Cancelled mid-row: the row is left out of the checkpoint and
+			// processed again on the next run rather than recorded as failed
+			tracing.EndSpan(span, err)
+			state.interrupted = true
+			break
+		}
+		if err != nil {
+			log.WithContext(rowCtx).WithError(err).WithField("line", line).Warn("Failed to process row")
+			rowResult.Outcome = model.OutcomeFailed
+			rowResult.Error = err.Error()
 			errorCount++
 		}
+		span.SetAttributes(
+			attribute.String("order_number", rowResult.OrderNumber),
+			attribute.String("tracking_number", rowResult.TrackingNumber),
+			attribute.String("outcome", rowResult.Outcome),
+		)
+		tracing.EndSpan(span, err)
 
+		result.Rows = append(result.Rows, rowResult)
 		rowCount++
+		commit(false)
+	}
+
+	// Create one shipment per order and tracking number for item rows
+	if indices.isItemFormat() {
+		if state.interrupted {
+			log.Warn("Interrupted by shutdown before creating shipments")
+			return nil, false, true
+		}
+		errorCount += p.shipItemGroups(ctx, state, result)
+		if state.interrupted {
+			log.Warn("Interrupted by shutdown while creating shipments")
+			return nil, false, true
+		}
+	}
+
+	// Submit the tracks queued in bulk mode and wait for Magento to store
+	// them. Rows are journaled with their bulk once Magento accepted it, so
+	// the next run polls the bulk instead of submitting the tracks again.
+	if !state.interrupted {
+		errorCount += p.submitBulkTracks(ctx, state, result, commit)
+	}
+	if !state.interrupted {
+		commit(true)
+	}
+
+	if state.interrupted {
+		state.countRows(result)
+		log.WithField("rows_completed", len(result.Rows)).Warn("Interrupted by shutdown, progress saved in checkpoint")
+		return nil, false, true
+	}
+
+	if len(state.unmappedCarriers) > 0 {
+		result.UnmappedCarriers = state.unmappedCarriers
+		log.WithField("unmapped_carriers", state.unmappedCarriers).Warn("Carrier values without an alias")
 	}
 
-	elapsed := time.Since(startTime)
+	result.Elapsed = time.Since(startTime)
 	log.WithFields(logrus.Fields{
-		"elapsed":      elapsed,
-		"row_count":    rowCount,
-		"error_count":  errorCount,
-		"success_rate": fmt.Sprintf("%.2f%%", 100*(float64(rowCount-errorCount)/float64(rowCount))),
+		"elapsed":              result.Elapsed,
+		"row_count":            rowCount,
+		"error_count":          errorCount,
+		"skipped_count":        result.Count(model.OutcomeSkipped),
+		"pending_count":        result.Count(model.OutcomePending),
+		"notifications_sent":   result.NotificationCount(model.NotificationSent),
+		"notifications_failed": result.NotificationCount(model.NotificationFailed),
+		"comments_added":       result.CommentCount(model.CommentAdded),
+		"comments_failed":      result.CommentCount(model.CommentFailed),
+		"statuses_updated":     result.StatusUpdateCount(model.StatusUpdated),
+		"statuses_failed":      result.StatusUpdateCount(model.StatusUpdateFailed),
+		"carrier_mismatches":   result.CarrierMismatchCount(),
+		"success_rate":         fmt.Sprintf("%.2f%%", 100*(float64(rowCount-errorCount)/float64(rowCount))),
 	}).Info("Completed processing file")
 
+	state.countRows(result)
+
 	// Return true if there were no errors or if the error count is acceptable
-	return errorCount == 0 || float64(errorCount)/float64(rowCount) < 0.05 // 5% error threshold
+	return result, errorCount == 0 || float64(errorCount)/float64(rowCount) < 0.05, false // 5% error threshold
+}
+
+// prefetchOrders batch-resolves the orders referenced by a file. Failures are
+// only logged, as every row falls back to its own lookup.
+func (p *CSVProcessor) prefetchOrders(ctx context.Context, filePath string, indices columnIndices) {
+	ctx, span := tracing.Tracer().Start(ctx, "prefetch orders")
+	defer span.End()
+	log := p.logger.WithContext(ctx).WithField("file", filePath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.WithError(err).Warn("Failed to open file for prefetching orders")
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	if _, err := reader.Read(); err != nil {
+		return
+	}
+
+	var orderNumbers []string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || indices.orderNumber >= len(row) {
+			continue
+		}
+		orderNumbers = append(orderNumbers, row[indices.orderNumber])
+	}
+
+	if err := p.router.PrefetchOrders(ctx, filePath, orderNumbers); err != nil {
+		log.WithError(err).Warn("Failed to prefetch orders, falling back to per-row lookups")
+	}
 }
 
 // columnIndices holds the indices of the required columns
@@ -183,6 +797,12 @@ type columnIndices struct {
 	trackingNumber int
 	carrierCode    int
 	title          int
+	notify         int // Optional
+	action         int // Optional
+	shipmentID     int // Optional
+	skus           int // Optional
+	sku            int // Optional, item rows
+	qty            int // Optional, item rows
 }
 
 // getColumnIndices returns the indices of the required columns
@@ -192,6 +812,12 @@ func getColumnIndices(header []string) columnIndices {
 		trackingNumber: -1,
 		carrierCode:    -1,
 		title:          -1,
+		notify:         -1,
+		action:         -1,
+		shipmentID:     -1,
+		skus:           -1,
+		sku:            -1,
+		qty:            -1,
 	}
 
 	for i, col := range header {
@@ -204,43 +830,122 @@ func getColumnIndices(header []string) columnIndices {
 			indices.carrierCode = i
 		case "title":
 			indices.title = i
+		case "notify":
+			indices.notify = i
+		case "action":
+			indices.action = i
+		case "shipment_increment_id":
+			indices.shipmentID = i
+		case "skus":
+			indices.skus = i
+		case "sku":
+			indices.sku = i
+		case "qty":
+			indices.qty = i
 		}
 	}
 
 	return indices
 }
 
-// processRow processes a single row from the CSV file
-func (p *CSVProcessor) processRow(row []string, indices columnIndices) error {
-	// Extract tracking information from the row
-	trackingInfo := &model.TrackingInfo{
-		OrderNumber:    row[indices.orderNumber],
-		TrackingNumber: row[indices.trackingNumber],
-		CarrierCode:    row[indices.carrierCode],
-		Title:          row[indices.title],
+// isItemFormat reports whether rows carry shipment items, in which case rows
+// are grouped into new shipments rather than tracked on existing ones
+func (c columnIndices) isItemFormat() bool {
+	return c.sku != -1 && c.qty != -1
+}
+
+// countRows counts the rows handled in this run by outcome. Rows restored
+// from the checkpoint were counted by the run that handled them, except
+// pending bulk rows, which are counted by the run they become final in.
+func (f *fileState) countRows(result *model.FileResult) {
+	for i, row := range result.Rows {
+		if i < f.restored && len(f.bulkRows[i]) == 0 {
+			continue
+		}
+		if f.interrupted && row.Outcome == model.OutcomePending {
+			continue
+		}
+		metrics.RowsProcessed.WithLabelValues(row.Outcome).Inc()
+	}
+}
+
+// checkRow extracts the tracking information of a row, maps its carrier and
+// validates it, without calling Magento
+func (f *fileState) checkRow(row []string, indices columnIndices, result *model.RowResult) (*model.TrackingInfo, error) {
+	trackingInfo, err := parseRow(row, indices)
+	result.Action = trackingInfo.Action
+	result.OrderNumber = trackingInfo.OrderNumber
+	result.TrackingNumber = trackingInfo.TrackingNumber
+	result.CarrierCode = trackingInfo.CarrierCode
+	if err != nil {
+		return nil, err
 	}
 
+	// Map free-text carrier names to Magento carrier codes and fill in
+	// missing carriers from the tracking number format
+	f.resolveCarrier(trackingInfo, result)
+
 	// Validate the tracking information
-	if err := trackingInfo.Validate(); err != nil {
-		return fmt.Errorf("invalid tracking info: %w", err)
+	if err := f.validate(trackingInfo, result); err != nil {
+		return nil, fmt.Errorf("invalid tracking info: %w", err)
 	}
+	return trackingInfo, nil
+}
 
-	log := p.logger.WithFields(logrus.Fields{
+// processRow processes a single row from the CSV file
+func (p *CSVProcessor) processRow(ctx context.Context, file *fileState, row []string, indices columnIndices, result *model.RowResult) error {
+	// Extract and validate the tracking information
+	trackingInfo, err := file.checkRow(row, indices, result)
+	if err != nil {
+		return err
+	}
+
+	log := p.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"action":          trackingInfo.Action,
 		"order_number":    trackingInfo.OrderNumber,
 		"tracking_number": trackingInfo.TrackingNumber,
 		"carrier_code":    trackingInfo.CarrierCode,
 	})
 
 	log.Info("Processing tracking information")
+	if result.CarrierMismatch {
+		log.WithField("detected_carrier", result.DetectedCarrier).Warn("Carrier does not match the tracking number format")
+	}
+	if result.TrackingNumberWarning != "" {
+		log.WithField("warning", result.TrackingNumberWarning).Warn("Tracking number failed validation")
+	}
+
+	// Pick the Magento instance/store responsible for this order
+	magentoClient := p.router.ClientFor(file.path, trackingInfo.OrderNumber)
+	lookup := p.router.LookupFor(file.path, trackingInfo.OrderNumber)
+
+	// Check the carrier is one Magento knows, using its spelling of the code.
+	// Delete rows match tracks by number, so their carrier is not checked.
+	if trackingInfo.Action != model.ActionDelete {
+		if err := p.carriers.normalize(ctx, magentoClient, trackingInfo); err != nil {
+			return fmt.Errorf("invalid carrier: %w", err)
+		}
+		result.CarrierCode = trackingInfo.CarrierCode
+	}
 
 	// Get the order by increment ID (order number)
-	order, err := p.magentoClient.GetOrderByIncrementID(trackingInfo.OrderNumber)
+	order, err := lookup.GetOrderByIncrementID(ctx, trackingInfo.OrderNumber)
 	if err != nil {
 		return fmt.Errorf("failed to get order: %w", err)
 	}
+	result.OrderStatus = order.Status
+
+	// Check the order may receive tracking in its current status
+	if err := p.checkOrderStatus(order); errors.Is(err, errSkipOrderStatus) {
+		log.WithField("status", order.Status).Warn("Order status is configured to be skipped, skipping tracking update")
+		result.Outcome = model.OutcomeSkipped
+		return nil
+	} else if err != nil {
+		return err
+	}
 
 	// Get shipments for the order
-	shipments, err := p.magentoClient.GetShipmentsByOrderID(order.EntityID)
+	shipments, err := lookup.GetShipmentsByOrderID(ctx, order.EntityID)
 	if err != nil {
 		return fmt.Errorf("failed to get shipments: %w", err)
 	}
@@ -248,12 +953,21 @@ func (p *CSVProcessor) processRow(row []string, indices columnIndices) error {
 	// Skip if no shipments found
 	if len(shipments) == 0 {
 		log.Warn("No shipments found for order, skipping tracking update")
+		result.Outcome = model.OutcomeSkipped
 		return nil
 	}
 
-	// Use the first shipment (as per requirement, each order has only 1 shipment)
-	shipment := shipments[0]
-	
+	// Pick the shipment(s) the row applies to
+	targets, err := selectShipments(shipments, trackingInfo, p.config.Load().Tracking.ShipmentStrategy)
+	if errors.Is(err, errNoTargetShipment) {
+		log.Warn("No eligible shipment for order, skipping tracking update")
+		result.Outcome = model.OutcomeSkipped
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to select shipment: %w", err)
+	}
+
 	// Create tracking information for Magento API
 	track := &model.MagentoTrack{
 		OrderID:     order.EntityID,
@@ -262,11 +976,218 @@ func (p *CSVProcessor) processRow(row []string, indices columnIndices) error {
 		CarrierCode: trackingInfo.CarrierCode,
 	}
 
-	// Add tracking to the shipment
-	if err := p.magentoClient.AddTrackingToShipment(shipment.EntityID, track); err != nil {
+	if trackingInfo.Action == model.ActionDelete {
+		return p.deleteTrack(ctx, magentoClient, targets, track, result)
+	}
+
+	// In bulk mode new tracks are queued and submitted to Magento's message
+	// queue once the whole file was read. Dry runs record each track instead.
+	if p.config.Load().Magento.Bulk.Enabled && !p.config.Load().DryRun.Enabled && trackingInfo.Action == model.ActionAdd {
+		for i := range targets {
+			result.ShipmentIDs = append(result.ShipmentIDs, targets[i].EntityID)
+			file.queueBulkTrack(magentoClient, trackingInfo, order, &targets[i], track)
+		}
+		result.Outcome = model.OutcomePending
+		log.Info("Queued tracking information for bulk submission")
+		return nil
+	}
+
+	for i := range targets {
+		shipment := &targets[i]
+		result.ShipmentIDs = append(result.ShipmentIDs, shipment.EntityID)
+
+		if err := p.applyTrack(ctx, magentoClient, file, shipment, track, trackingInfo.Action); err != nil {
+			return err
+		}
+
+		log.WithField("shipment_id", shipment.EntityID).Info("Successfully updated tracking information")
+		p.afterTrack(ctx, magentoClient, file, trackingInfo, shipment.EntityID, result)
+	}
+
+	// Move the order on to its post-tracking status if configured
+	p.updateOrderStatus(ctx, magentoClient, order, result)
+
+	return nil
+}
+
+// afterTrack posts the shipment comment and customer notification that follow
+// a stored track. Failures are recorded on the row but do not fail it, as the
+// track is already stored.
+func (p *CSVProcessor) afterTrack(ctx context.Context, magentoClient *api.MagentoClient, file *fileState, trackingInfo *model.TrackingInfo, shipmentID int, result *model.RowResult) {
+	log := p.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"order_number":    trackingInfo.OrderNumber,
+		"tracking_number": trackingInfo.TrackingNumber,
+		"shipment_id":     shipmentID,
+	})
+
+	// Leave an audit trail on the shipment if enabled
+	if p.commentTmpl.Load() != nil {
+		if err := p.addComment(ctx, magentoClient, file.path, trackingInfo, shipmentID); err != nil {
+			log.WithError(err).Warn("Failed to add shipment comment")
+			result.Comment = model.CommentFailed
+			result.CommentError = err.Error()
+		} else if result.Comment != model.CommentFailed {
+			result.Comment = model.CommentAdded
+		}
+	}
+
+	// Notify the customer if enabled globally or requested by the row
+	notify := p.config.Load().Tracking.NotifyCustomer
+	if trackingInfo.Notify != nil {
+		notify = *trackingInfo.Notify
+	}
+	if notify {
+		if err := magentoClient.SendShipmentEmail(ctx, shipmentID); err != nil {
+			log.WithError(err).Warn("Failed to notify customer")
+			result.Notification = model.NotificationFailed
+			result.NotificationError = err.Error()
+		} else if result.Notification != model.NotificationFailed {
+			result.Notification = model.NotificationSent
+		}
+	}
+}
+
+// parseRow extracts the tracking information from a CSV row. The returned
+// tracking information is usable for reporting even when an error is returned.
+func parseRow(row []string, indices columnIndices) (*model.TrackingInfo, error) {
+	trackingInfo := &model.TrackingInfo{
+		Action:         model.ActionAdd,
+		OrderNumber:    row[indices.orderNumber],
+		TrackingNumber: row[indices.trackingNumber],
+		CarrierCode:    row[indices.carrierCode],
+		Title:          row[indices.title],
+	}
+	if indices.action != -1 && strings.TrimSpace(row[indices.action]) != "" {
+		trackingInfo.Action = strings.ToLower(strings.TrimSpace(row[indices.action]))
+	}
+	if indices.shipmentID != -1 {
+		trackingInfo.ShipmentIncrementID = strings.TrimSpace(row[indices.shipmentID])
+	}
+	if indices.skus != -1 {
+		trackingInfo.SKUs = parseSKUs(row[indices.skus])
+	}
+	if indices.sku != -1 {
+		trackingInfo.SKU = strings.TrimSpace(row[indices.sku])
+	}
+
+	if indices.qty != -1 && strings.TrimSpace(row[indices.qty]) != "" {
+		qty, err := strconv.ParseFloat(strings.TrimSpace(row[indices.qty]), 64)
+		if err != nil {
+			return trackingInfo, fmt.Errorf("invalid qty value: %w", err)
+		}
+		trackingInfo.Qty = qty
+	}
+
+	if indices.notify != -1 && strings.TrimSpace(row[indices.notify]) != "" {
+		notify, err := parseBool(row[indices.notify])
+		if err != nil {
+			return trackingInfo, fmt.Errorf("invalid notify value: %w", err)
+		}
+		trackingInfo.Notify = &notify
+	}
+
+	return trackingInfo, nil
+}
+
+// applyTrack adds the track to the shipment, or reconciles the shipment's
+// tracks for replace rows
+func (p *CSVProcessor) applyTrack(ctx context.Context, magentoClient *api.MagentoClient, file *fileState, shipment *model.MagentoShipment, track *model.MagentoTrack, action string) error {
+	if action == model.ActionReplace {
+		// Keep the tracks earlier replace rows of this file wrote to the shipment
+		tracks := append(file.replacedTracks[shipment.EntityID], *track)
+		if err := magentoClient.ReplaceShipmentTracks(ctx, shipment.EntityID, shipment.Tracks, tracks); err != nil {
+			return fmt.Errorf("failed to replace tracking: %w", err)
+		}
+		file.replacedTracks[shipment.EntityID] = tracks
+		return nil
+	}
+
+	// Add tracking to the shipment; copy the track as the client sets its parent
+	shipmentTrack := *track
+	if err := magentoClient.AddTrackingToShipment(ctx, shipment.EntityID, &shipmentTrack); err != nil {
 		return fmt.Errorf("failed to add tracking: %w", err)
 	}
+	return nil
+}
+
+// deleteTrack removes the shipments' tracks matching the row's tracking number
+// and, when given, carrier code
+func (p *CSVProcessor) deleteTrack(ctx context.Context, magentoClient *api.MagentoClient, shipments []model.MagentoShipment, track *model.MagentoTrack, result *model.RowResult) error {
+	log := p.logger.WithContext(ctx).WithField("tracking_number", track.TrackNumber)
+
+	deleted := 0
+	for _, shipment := range shipments {
+		for i := range shipment.Tracks {
+			if !shipment.Tracks[i].Matches(track) {
+				continue
+			}
+			if err := magentoClient.DeleteTrack(ctx, shipment.Tracks[i].EntityID); err != nil {
+				return fmt.Errorf("failed to delete tracking: %w", err)
+			}
+			result.ShipmentIDs = append(result.ShipmentIDs, shipment.EntityID)
+			deleted++
+		}
+	}
+
+	if deleted == 0 {
+		log.Warn("Track not found on shipment, nothing to delete")
+		result.Outcome = model.OutcomeSkipped
+		return nil
+	}
 
-	log.Info("Successfully updated tracking information")
+	log.WithField("deleted", deleted).Info("Successfully deleted tracking information")
 	return nil
 }
+
+// addComment renders the comment template and posts it to the shipment
+func (p *CSVProcessor) addComment(ctx context.Context, magentoClient *api.MagentoClient, filePath string, trackingInfo *model.TrackingInfo, shipmentID int) error {
+	text, err := p.renderComment(filePath, trackingInfo, shipmentID)
+	if err != nil {
+		return err
+	}
+
+	comment := &model.MagentoShipmentComment{
+		Comment: text,
+	}
+	if p.config.Load().Tracking.Comment.VisibleOnFront {
+		comment.IsVisibleOnFront = 1
+	}
+	if p.config.Load().Tracking.Comment.NotifyCustomer {
+		comment.IsCustomerNotified = 1
+	}
+
+	return magentoClient.AddShipmentComment(ctx, shipmentID, comment)
+}
+
+// renderComment renders the shipment comment template for a row
+func (p *CSVProcessor) renderComment(filePath string, trackingInfo *model.TrackingInfo, shipmentID int) (string, error) {
+	tmpl := p.commentTmpl.Load()
+	if tmpl == nil {
+		return "", errors.New("shipment comments are disabled")
+	}
+
+	var text strings.Builder
+	err := tmpl.Execute(&text, commentData{
+		OrderNumber:    trackingInfo.OrderNumber,
+		TrackingNumber: trackingInfo.TrackingNumber,
+		CarrierCode:    trackingInfo.CarrierCode,
+		Title:          trackingInfo.Title,
+		File:           filepath.Base(filePath),
+		ShipmentID:     shipmentID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render comment: %w", err)
+	}
+	return text.String(), nil
+}
+
+// parseBool parses the boolean spellings commonly found in CSV exports
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "y":
+		return true, nil
+	case "0", "false", "no", "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", value)
+}
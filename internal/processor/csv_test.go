@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"testing"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/model"
+)
+
+func TestValidateTrackingNumberCheck(t *testing.T) {
+	tests := []struct {
+		mode    string
+		number  string
+		wantErr bool
+		warning bool
+	}{
+		// Valid numbers pass in every mode
+		{model.TrackingNumberCheckReject, "1Z999AA10123456784", false, false},
+		{model.TrackingNumberCheckWarn, "1Z999AA10123456784", false, false},
+		{model.TrackingNumberCheckOff, "1Z999AA10123456784", false, false},
+
+		// Transposed digits fail the check digit
+		{model.TrackingNumberCheckReject, "1Z999AA10123465784", true, false},
+		{model.TrackingNumberCheckWarn, "1Z999AA10123465784", false, true},
+		{model.TrackingNumberCheckOff, "1Z999AA10123465784", false, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mode+"/"+tc.number, func(t *testing.T) {
+			f := newFileState("tracking.csv", &config.TrackingConfig{TrackingNumberCheck: tc.mode}, nil)
+			info := &model.TrackingInfo{
+				Action:         model.ActionAdd,
+				OrderNumber:    "000000123",
+				TrackingNumber: tc.number,
+				CarrierCode:    model.CarrierUPS,
+				Title:          "United Parcel Service",
+			}
+			result := &model.RowResult{}
+
+			err := f.validate(info, result)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("validate() = %v, want error %v", err, tc.wantErr)
+			}
+			if (result.TrackingNumberWarning != "") != tc.warning {
+				t.Errorf("warning = %q, want warning %v", result.TrackingNumberWarning, tc.warning)
+			}
+		})
+	}
+}
+
+func TestValidateOtherErrorsIgnoreCheckMode(t *testing.T) {
+	for _, mode := range []string{model.TrackingNumberCheckWarn, model.TrackingNumberCheckOff} {
+		f := newFileState("tracking.csv", &config.TrackingConfig{TrackingNumberCheck: mode}, nil)
+		info := &model.TrackingInfo{Action: model.ActionAdd, TrackingNumber: "1Z999AA10123456784"}
+		if err := f.validate(info, &model.RowResult{}); err == nil {
+			t.Errorf("validate() in %s mode accepted a row without order number", mode)
+		}
+	}
+}
+
+func TestCheckRowDeleteSkipsCarrierDetection(t *testing.T) {
+	tracking := &config.TrackingConfig{DetectCarrier: true, TrackingNumberCheck: model.TrackingNumberCheckWarn}
+	indices := getColumnIndices([]string{"action", "order_number", "tracking_number", "carrier_code", "title"})
+
+	tests := []struct {
+		name     string
+		row      []string
+		mismatch bool
+	}{
+		{"add with mismatched carrier", []string{"add", "000000123", "1Z999AA10123456784", "fedex", "FedEx"}, true},
+		{"delete with mismatched carrier", []string{"delete", "000000123", "1Z999AA10123456784", "fedex", ""}, false},
+		{"delete without carrier", []string{"delete", "000000123", "1Z999AA10123456784", "", ""}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFileState("tracking.csv", tracking, model.NewCarrierNormalizer(nil))
+			result := &model.RowResult{}
+			info, err := f.checkRow(tc.row, indices, result)
+			if err != nil {
+				t.Fatalf("checkRow() = %v", err)
+			}
+			if result.CarrierMismatch != tc.mismatch {
+				t.Errorf("CarrierMismatch = %v, want %v", result.CarrierMismatch, tc.mismatch)
+			}
+			if tc.row[0] == model.ActionDelete && info.CarrierCode != tc.row[3] {
+				t.Errorf("carrier code = %q, want %q unchanged", info.CarrierCode, tc.row[3])
+			}
+		})
+	}
+}
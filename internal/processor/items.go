@@ -0,0 +1,288 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+	"tracking-updater/internal/tracing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// itemGroup is one box: the item rows of a file sharing an order and
+// tracking number, which become a single new shipment
+type itemGroup struct {
+	trackingInfo *model.TrackingInfo   // First row of the group
+	items        []*model.TrackingInfo // One entry per row
+	rows         []int                 // Indexes into FileResult.Rows
+}
+
+// collectItemRow validates an item row and adds it to its group
+func (f *fileState) collectItemRow(row []string, indices columnIndices, result *model.RowResult, resultIndex int) error {
+	trackingInfo, err := parseRow(row, indices)
+	result.Action = trackingInfo.Action
+	result.OrderNumber = trackingInfo.OrderNumber
+	result.TrackingNumber = trackingInfo.TrackingNumber
+	result.CarrierCode = trackingInfo.CarrierCode
+	if err != nil {
+		return err
+	}
+
+	f.resolveCarrier(trackingInfo, result)
+
+	if trackingInfo.Action != model.ActionAdd {
+		return fmt.Errorf("item rows only support the %s action", model.ActionAdd)
+	}
+	if trackingInfo.SKU == "" {
+		return fmt.Errorf("sku is required for item rows")
+	}
+	if err := f.validate(trackingInfo, result); err != nil {
+		return fmt.Errorf("invalid tracking info: %w", err)
+	}
+
+	key := strings.TrimSpace(trackingInfo.OrderNumber) + "\x00" + strings.TrimSpace(trackingInfo.TrackingNumber)
+	group, ok := f.itemGroupIndex[key]
+	if !ok {
+		group = &itemGroup{trackingInfo: trackingInfo}
+		f.itemGroupIndex[key] = group
+		f.itemGroups = append(f.itemGroups, group)
+	} else if !strings.EqualFold(group.trackingInfo.CarrierCode, trackingInfo.CarrierCode) {
+		return fmt.Errorf("carrier %s conflicts with carrier %s of earlier rows for tracking number %s",
+			trackingInfo.CarrierCode, group.trackingInfo.CarrierCode, trackingInfo.TrackingNumber)
+	}
+
+	group.items = append(group.items, trackingInfo)
+	group.rows = append(group.rows, resultIndex)
+	return nil
+}
+
+// shipItemGroups creates a shipment for every collected group and copies the
+// outcome onto the group's rows. It returns the number of rows that failed.
+func (p *CSVProcessor) shipItemGroups(ctx context.Context, file *fileState, result *model.FileResult) int {
+	failed := 0
+
+	for _, group := range file.itemGroups {
+		if p.interrupted(ctx) {
+			file.interrupted = true
+			break
+		}
+
+		groupResult := model.RowResult{Outcome: model.OutcomeSuccess}
+		groupCtx, span := tracing.Tracer().Start(ctx, "ship item group", trace.WithAttributes(
+			attribute.String("order_number", group.trackingInfo.OrderNumber),
+			attribute.String("tracking_number", group.trackingInfo.TrackingNumber),
+			attribute.Int("item_count", len(group.items)),
+		))
+		var recorder *api.Recorder
+		if p.config.Load().DryRun.Enabled {
+			recorder = &api.Recorder{}
+			groupCtx = api.WithRecorder(groupCtx, recorder)
+		}
+		err := p.shipItemGroup(groupCtx, file, group, &groupResult)
+		if recorder != nil {
+			groupResult.WouldPost = recorder.Requests()
+		}
+		if err != nil {
+			p.logger.WithContext(groupCtx).WithError(err).WithFields(logrus.Fields{
+				"order_number":    group.trackingInfo.OrderNumber,
+				"tracking_number": group.trackingInfo.TrackingNumber,
+			}).Warn("Failed to create shipment")
+			groupResult.Outcome = model.OutcomeFailed
+			groupResult.Error = err.Error()
+			failed += len(group.rows)
+		}
+		span.SetAttributes(attribute.String("outcome", groupResult.Outcome))
+		tracing.EndSpan(span, err)
+
+		// The requests of the group are listed on its first row only
+		if len(group.rows) > 0 {
+			result.Rows[group.rows[0]].WouldPost = groupResult.WouldPost
+		}
+		for _, i := range group.rows {
+			row := &result.Rows[i]
+			row.Outcome = groupResult.Outcome
+			row.Error = groupResult.Error
+			if groupResult.CarrierCode != "" {
+				row.CarrierCode = groupResult.CarrierCode
+			}
+			row.ShipmentIDs = groupResult.ShipmentIDs
+			row.Notification = groupResult.Notification
+			row.Comment = groupResult.Comment
+			row.OrderStatus = groupResult.OrderStatus
+			row.StatusUpdate = groupResult.StatusUpdate
+			row.StatusUpdateError = groupResult.StatusUpdateError
+		}
+	}
+
+	return failed
+}
+
+// shipItemGroup creates a shipment with the group's items and tracking number
+func (p *CSVProcessor) shipItemGroup(ctx context.Context, file *fileState, group *itemGroup, result *model.RowResult) error {
+	trackingInfo := group.trackingInfo
+	log := p.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"order_number":    trackingInfo.OrderNumber,
+		"tracking_number": trackingInfo.TrackingNumber,
+		"carrier_code":    trackingInfo.CarrierCode,
+		"row_count":       len(group.rows),
+	})
+
+	log.Info("Creating shipment from item rows")
+
+	magentoClient := p.router.ClientFor(file.path, trackingInfo.OrderNumber)
+	lookup := p.router.LookupFor(file.path, trackingInfo.OrderNumber)
+
+	// Check the carrier is one Magento knows, using its spelling of the code
+	if err := p.carriers.normalize(ctx, magentoClient, trackingInfo); err != nil {
+		return fmt.Errorf("invalid carrier: %w", err)
+	}
+	result.CarrierCode = trackingInfo.CarrierCode
+
+	order, err := lookup.GetOrderByIncrementID(ctx, trackingInfo.OrderNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+	result.OrderStatus = order.Status
+
+	// Check the order may be shipped in its current status
+	if err := p.checkOrderStatus(order); errors.Is(err, errSkipOrderStatus) {
+		log.WithField("status", order.Status).Warn("Order status is configured to be skipped, skipping shipment")
+		result.Outcome = model.OutcomeSkipped
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	shipments, err := lookup.GetShipmentsByOrderID(ctx, order.EntityID)
+	if err != nil {
+		return fmt.Errorf("failed to get shipments: %w", err)
+	}
+
+	// A box whose tracking number is already on a shipment was shipped by an
+	// earlier run of this file, so shipping it again would duplicate it
+	track := &model.MagentoTrack{
+		TrackNumber: trackingInfo.TrackingNumber,
+		CarrierCode: trackingInfo.CarrierCode,
+	}
+	for _, shipment := range shipments {
+		for i := range shipment.Tracks {
+			if shipment.Tracks[i].Matches(track) {
+				log.WithField("shipment_id", shipment.EntityID).Warn("Tracking number already shipped, skipping")
+				result.Outcome = model.OutcomeSkipped
+				result.ShipmentIDs = []int{shipment.EntityID}
+				return nil
+			}
+		}
+	}
+
+	items, err := shipItems(order, group.items)
+	if err != nil {
+		return err
+	}
+
+	request := &model.MagentoShipOrderRequest{
+		Items: items,
+		Tracks: []model.MagentoTrackCreation{{
+			TrackNumber: trackingInfo.TrackingNumber,
+			Title:       trackingInfo.Title,
+			CarrierCode: trackingInfo.CarrierCode,
+		}},
+	}
+
+	// Notify the customer if enabled globally or requested by any row of the box
+	request.Notify = p.config.Load().Tracking.NotifyCustomer
+	for _, item := range group.items {
+		if item.Notify != nil {
+			request.Notify = *item.Notify
+			break
+		}
+	}
+
+	if p.commentTmpl.Load() != nil {
+		text, err := p.renderComment(file.path, trackingInfo, 0)
+		if err != nil {
+			return err
+		}
+		request.Comment = &model.MagentoShipComment{Comment: text}
+		if p.config.Load().Tracking.Comment.VisibleOnFront {
+			request.Comment.IsVisibleOnFront = 1
+		}
+		if p.config.Load().Tracking.Comment.NotifyCustomer {
+			request.Notify = true
+			request.AppendComment = true
+		}
+	}
+
+	shipmentID, err := magentoClient.CreateShipment(ctx, order.EntityID, request)
+	if err != nil {
+		return err
+	}
+
+	result.ShipmentIDs = []int{shipmentID}
+	if request.Notify {
+		result.Notification = model.NotificationSent
+	}
+	if request.Comment != nil {
+		result.Comment = model.CommentAdded
+	}
+
+	// Move the order on to its post-tracking status if configured
+	p.updateOrderStatus(ctx, magentoClient, order, result)
+
+	log.WithField("shipment_id", shipmentID).Info("Successfully created shipment with tracking information")
+	return nil
+}
+
+// shipItems resolves the SKUs of the item rows to order items, summing the
+// quantities of rows for the same item and checking what is left to ship
+func shipItems(order *model.MagentoOrder, rows []*model.TrackingInfo) ([]model.MagentoShipItem, error) {
+	var items []model.MagentoShipItem
+	index := make(map[int]int) // Order item ID to index in items
+
+	for _, row := range rows {
+		orderItem := findOrderItem(order, row.SKU)
+		if orderItem == nil {
+			return nil, fmt.Errorf("sku %s not found on order %s", row.SKU, order.IncrementID)
+		}
+
+		i, ok := index[orderItem.ItemID]
+		if !ok {
+			i = len(items)
+			index[orderItem.ItemID] = i
+			items = append(items, model.MagentoShipItem{OrderItemID: orderItem.ItemID})
+		}
+		items[i].Qty += row.Qty
+
+		if remaining := orderItem.QtyToShip(); items[i].Qty > remaining {
+			return nil, fmt.Errorf("qty %g of sku %s exceeds the %g left to ship", items[i].Qty, row.SKU, remaining)
+		}
+	}
+
+	return items, nil
+}
+
+// findOrderItem returns the order item to ship for a SKU. Configurable
+// products carry the child's SKU on the parent item too, and Magento ships
+// the parent, so top-level items are preferred.
+func findOrderItem(order *model.MagentoOrder, sku string) *model.MagentoOrderItem {
+	var child *model.MagentoOrderItem
+	for i := range order.Items {
+		item := &order.Items[i]
+		if !strings.EqualFold(strings.TrimSpace(item.SKU), sku) {
+			continue
+		}
+		if item.ParentItemID == 0 {
+			return item
+		}
+		if child == nil {
+			child = item
+		}
+	}
+	return child
+}
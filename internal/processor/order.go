@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"tracking-updater/internal/api"
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errSkipOrderStatus is returned when the order status is configured to be
+// skipped, in which case the row is skipped rather than failed
+var errSkipOrderStatus = errors.New("order status is configured to be skipped")
+
+// checkOrderStatus applies the configured order status rules. Rejected
+// statuses win over skipped ones; when an allow list is configured, any
+// status not on it is rejected.
+func (p *CSVProcessor) checkOrderStatus(order *model.MagentoOrder) error {
+	rules := p.config.Load().Tracking.OrderStatus
+
+	if containsStatus(rules.Reject, order.Status) {
+		return fmt.Errorf("order %s has rejected status %s", order.IncrementID, order.Status)
+	}
+	if containsStatus(rules.Skip, order.Status) {
+		return errSkipOrderStatus
+	}
+	if len(rules.Allowed) > 0 && !containsStatus(rules.Allowed, order.Status) {
+		return fmt.Errorf("order %s has status %s, which is not allowed", order.IncrementID, order.Status)
+	}
+	return nil
+}
+
+// updateOrderStatus posts the configured order comment once tracking was
+// added, changing the order status when one is configured. A failure is
+// recorded on the row but does not fail it, as the tracking is already stored.
+func (p *CSVProcessor) updateOrderStatus(ctx context.Context, magentoClient *api.MagentoClient, order *model.MagentoOrder, result *model.RowResult) {
+	after := p.config.Load().Tracking.OrderStatus.AfterTracking
+	if after.Status == "" {
+		return
+	}
+
+	log := p.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"order_number": order.IncrementID,
+		"status":       order.Status,
+		"new_status":   after.Status,
+	})
+
+	// Earlier rows for the same order may have updated it already
+	if strings.EqualFold(order.Status, after.Status) {
+		log.Debug("Order already has the target status")
+		return
+	}
+
+	comment := &model.MagentoOrderStatusHistory{
+		Comment: after.Comment,
+		Status:  after.Status,
+	}
+	if after.NotifyCustomer {
+		comment.IsCustomerNotified = 1
+	}
+	if after.VisibleOnFront {
+		comment.IsVisibleOnFront = 1
+	}
+
+	if err := magentoClient.AddOrderComment(ctx, order.EntityID, comment); err != nil {
+		log.WithError(err).Warn("Failed to update order status")
+		result.StatusUpdate = model.StatusUpdateFailed
+		result.StatusUpdateError = err.Error()
+		return
+	}
+
+	log.Info("Updated order status")
+	result.StatusUpdate = model.StatusUpdated
+}
+
+// containsStatus reports whether the status is in the list, ignoring case
+func containsStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if strings.EqualFold(strings.TrimSpace(s), status) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"tracking-updater/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reportSuffix is appended to the archived file name to name its report
+const reportSuffix = ".report.json"
+
+// writeReport stores the result of a file as JSON in the report directory,
+// when one is configured
+func (p *CSVProcessor) writeReport(result *model.FileResult) {
+	dir := p.config.Load().FileWatch.ReportDir
+	if dir == "" {
+		return
+	}
+
+	log := p.logger.WithField("file", result.File)
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal file report")
+		return
+	}
+
+	path := filepath.Join(dir, p.config.Load().FileWatch.ArchiveName(result.File)+reportSuffix)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.WithError(err).Error("Failed to write file report")
+		return
+	}
+
+	log.WithField("report", path).Info("Wrote file report")
+}
+
+// copyFile copies a processed file into dir, for dry runs, which leave the
+// file itself in place
+func (p *CSVProcessor) copyFile(log *logrus.Entry, filePath, dir string) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		log.WithError(err).Error("Failed to open file for copying")
+		return
+	}
+	defer src.Close()
+
+	destinationPath := filepath.Join(dir, p.config.Load().FileWatch.ArchiveName(filePath))
+	dst, err := os.Create(destinationPath)
+	if err != nil {
+		log.WithError(err).Error("Failed to create file copy")
+		return
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		log.WithError(err).Error("Failed to copy file")
+		return
+	}
+	log.WithField("destination", destinationPath).Info("Copied file")
+}
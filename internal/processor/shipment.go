@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tracking-updater/internal/model"
+)
+
+// Shipment selection strategies, used when a row names neither a shipment
+// nor the SKUs it contains
+const (
+	strategyFirst         = "first"
+	strategyLatest        = "latest"
+	strategyAll           = "all"
+	strategyUntrackedOnly = "untracked-only"
+)
+
+// errNoTargetShipment is returned when no shipment is eligible for the row
+// and the row should be skipped rather than failed
+var errNoTargetShipment = errors.New("no eligible shipment")
+
+// selectShipments picks the shipments a row applies to. A shipment increment
+// ID on the row wins over a SKU list, which wins over the configured strategy.
+// Rows whose target cannot be determined unambiguously are rejected.
+func selectShipments(shipments []model.MagentoShipment, trackingInfo *model.TrackingInfo, strategy string) ([]model.MagentoShipment, error) {
+	if trackingInfo.ShipmentIncrementID != "" {
+		for _, shipment := range shipments {
+			if shipment.IncrementID == trackingInfo.ShipmentIncrementID {
+				return []model.MagentoShipment{shipment}, nil
+			}
+		}
+		return nil, fmt.Errorf("shipment %s not found on order", trackingInfo.ShipmentIncrementID)
+	}
+
+	if len(trackingInfo.SKUs) > 0 {
+		var matches []model.MagentoShipment
+		for _, shipment := range shipments {
+			if shipmentHasSKUs(&shipment, trackingInfo.SKUs) {
+				matches = append(matches, shipment)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("no shipment contains SKUs %s", strings.Join(trackingInfo.SKUs, ", "))
+		case 1:
+			return matches, nil
+		default:
+			return nil, fmt.Errorf("ambiguous target: %d shipments contain SKUs %s", len(matches), strings.Join(trackingInfo.SKUs, ", "))
+		}
+	}
+
+	switch strategy {
+	case strategyFirst, "":
+		return shipments[:1], nil
+
+	case strategyLatest:
+		latest := shipments[0]
+		for _, shipment := range shipments[1:] {
+			if shipment.EntityID > latest.EntityID {
+				latest = shipment
+			}
+		}
+		return []model.MagentoShipment{latest}, nil
+
+	case strategyAll:
+		return shipments, nil
+
+	case strategyUntrackedOnly:
+		var untracked []model.MagentoShipment
+		for _, shipment := range shipments {
+			if len(shipment.Tracks) == 0 {
+				untracked = append(untracked, shipment)
+			}
+		}
+		switch len(untracked) {
+		case 0:
+			return nil, errNoTargetShipment
+		case 1:
+			return untracked, nil
+		default:
+			return nil, fmt.Errorf("ambiguous target: %d shipments have no tracking", len(untracked))
+		}
+	}
+
+	return nil, fmt.Errorf("unknown shipment strategy %q", strategy)
+}
+
+// shipmentHasSKUs reports whether the shipment contains all of the SKUs
+func shipmentHasSKUs(shipment *model.MagentoShipment, skus []string) bool {
+	for _, sku := range skus {
+		if !shipment.HasSKU(sku) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSKUs splits a SKU list column; SKUs are separated by "|" or ";"
+func parseSKUs(value string) []string {
+	var skus []string
+	for _, sku := range strings.FieldsFunc(value, func(r rune) bool { return r == '|' || r == ';' }) {
+		if sku = strings.TrimSpace(sku); sku != "" {
+			skus = append(skus, sku)
+		}
+	}
+	return skus
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tracking-updater/internal/model"
+)
+
+func TestSelectShipments(t *testing.T) {
+	tracked := []model.MagentoTrack{{TrackNumber: "1Z999AA10123456784"}}
+	shipments := []model.MagentoShipment{
+		{EntityID: 20, IncrementID: "000000020", Items: []model.MagentoShipmentItem{{SKU: "shirt"}, {SKU: "socks"}}, Tracks: tracked},
+		{EntityID: 30, IncrementID: "000000030", Items: []model.MagentoShipmentItem{{SKU: "shoes"}}},
+		{EntityID: 10, IncrementID: "000000010", Items: []model.MagentoShipmentItem{{SKU: "shirt"}}, Tracks: tracked},
+	}
+	allTracked := []model.MagentoShipment{shipments[0], shipments[2]}
+	untrackedTwice := []model.MagentoShipment{shipments[1], {EntityID: 40, IncrementID: "000000040"}}
+
+	tests := []struct {
+		name       string
+		shipments  []model.MagentoShipment
+		shipmentID string
+		skus       []string
+		strategy   string
+		want       []int  // Entity IDs of the selected shipments
+		wantErr    string // Substring of the error, if any
+	}{
+		// Strategies
+		{"default strategy", shipments, "", nil, "", []int{20}, ""},
+		{"first", shipments, "", nil, strategyFirst, []int{20}, ""},
+		{"latest", shipments, "", nil, strategyLatest, []int{30}, ""},
+		{"all", shipments, "", nil, strategyAll, []int{20, 30, 10}, ""},
+		{"untracked-only", shipments, "", nil, strategyUntrackedOnly, []int{30}, ""},
+		{"untracked-only without untracked shipment", allTracked, "", nil, strategyUntrackedOnly, nil, errNoTargetShipment.Error()},
+		{"untracked-only with several untracked shipments", untrackedTwice, "", nil, strategyUntrackedOnly, nil, "ambiguous target: 2 shipments have no tracking"},
+		{"unknown strategy", shipments, "", nil, "random", nil, `unknown shipment strategy "random"`},
+
+		// Shipment increment IDs win over SKUs and strategy
+		{"shipment increment ID", shipments, "000000010", []string{"shoes"}, strategyAll, []int{10}, ""},
+		{"unknown shipment increment ID", shipments, "000000099", nil, strategyFirst, nil, "shipment 000000099 not found on order"},
+
+		// SKUs win over strategy
+		{"SKUs of one shipment", shipments, "", []string{"shirt", "SOCKS"}, strategyLatest, []int{20}, ""},
+		{"SKUs of no shipment", shipments, "", []string{"hat"}, strategyFirst, nil, "no shipment contains SKUs hat"},
+		{"SKUs of several shipments", shipments, "", []string{"shirt"}, strategyFirst, nil, "ambiguous target: 2 shipments contain SKUs shirt"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			info := &model.TrackingInfo{ShipmentIncrementID: tc.shipmentID, SKUs: tc.skus}
+			selected, err := selectShipments(tc.shipments, info, tc.strategy)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("selectShipments() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectShipments() error = %v", err)
+			}
+
+			var got []int
+			for _, shipment := range selected {
+				got = append(got, shipment.EntityID)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("selectShipments() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSelectShipmentsNoEligibleShipmentIsSkipped(t *testing.T) {
+	tracked := []model.MagentoShipment{{EntityID: 1, Tracks: []model.MagentoTrack{{TrackNumber: "1Z999AA10123456784"}}}}
+	_, err := selectShipments(tracked, &model.TrackingInfo{}, strategyUntrackedOnly)
+	if !errors.Is(err, errNoTargetShipment) {
+		t.Errorf("selectShipments() error = %v, want errNoTargetShipment", err)
+	}
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/model"
+)
+
+// ValidateFile parses and validates every row of a file without calling
+// Magento. Carrier aliases, carrier detection and tracking number checks are
+// applied as in processing; valid rows get the success outcome.
+func ValidateFile(cfg *config.Config, filePath string) (*model.FileResult, error) {
+	startTime := time.Now()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	indices := getColumnIndices(header)
+	if indices.orderNumber == -1 || indices.trackingNumber == -1 || indices.carrierCode == -1 || indices.title == -1 {
+		return nil, fmt.Errorf("CSV file does not have required columns")
+	}
+
+	result := &model.FileResult{File: filePath, StartedAt: startTime}
+	state := newFileState(filePath, &cfg.Tracking, newCarrierNormalizer(cfg.Tracking.CarrierAliases))
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowResult := model.RowResult{Outcome: model.OutcomeFailed, Error: err.Error()}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rowResult.Line = parseErr.Line
+			}
+			result.Rows = append(result.Rows, rowResult)
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		rowResult := model.RowResult{Line: line, Outcome: model.OutcomeSuccess}
+
+		if indices.isItemFormat() {
+			err = state.collectItemRow(row, indices, &rowResult, len(result.Rows))
+		} else {
+			_, err = state.checkRow(row, indices, &rowResult)
+		}
+		if err != nil {
+			rowResult.Outcome = model.OutcomeFailed
+			rowResult.Error = err.Error()
+		}
+		result.Rows = append(result.Rows, rowResult)
+	}
+
+	if len(state.unmappedCarriers) > 0 {
+		result.UnmappedCarriers = state.unmappedCarriers
+	}
+	result.Elapsed = time.Since(startTime)
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"tracking-updater/config"
+	"tracking-updater/internal/health"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout bounds how long Stop waits for open requests
+const shutdownTimeout = 5 * time.Second
+
+// Server serves the operational endpoints: Prometheus metrics, liveness at
+// /healthz and readiness at /readyz
+type Server struct {
+	config     *config.HTTPConfig
+	logger     *logrus.Logger
+	httpServer *http.Server
+}
+
+// NewServer creates a new operational HTTP server
+func NewServer(cfg *config.HTTPConfig, checks *health.Registry, logger *logrus.Logger) *Server {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.MetricsPath, promhttp.Handler())
+	mux.Handle("/healthz", checks.LivenessHandler())
+	mux.Handle("/readyz", checks.ReadinessHandler())
+
+	return &Server{
+		config: cfg,
+		logger: logger,
+		httpServer: &http.Server{
+			Addr:              cfg.Address,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Start begins serving in the background
+func (s *Server) Start() {
+	log := s.logger.WithField("address", s.config.Address)
+	log.Info("Starting HTTP server")
+
+	go func() {
+		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("HTTP server failed")
+		}
+	}()
+}
+
+// Stop shuts the server down, waiting for open requests to finish
+func (s *Server) Stop() {
+	s.logger.Info("Stopping HTTP server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		s.logger.WithError(err).Warn("Failed to shut down HTTP server cleanly")
+	}
+}
@@ -10,18 +10,38 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Setup initializes the logger with the given configuration
-func Setup(cfg *config.LogConfig) *logrus.Logger {
+// Setup initializes the logger with the given configuration, logging to out
+// and, when enabled, to the log file
+func Setup(cfg *config.LogConfig, out io.Writer) *logrus.Logger {
 	log := logrus.New()
 
-	// Set log level
+	// Set log level and format
+	Reconfigure(log, cfg)
+
+	// Set log output
+	if cfg.EnableFile && cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err == nil {
+			log.SetOutput(io.MultiWriter(out, file))
+		} else {
+			log.Warn("Failed to log to file, using default stderr")
+		}
+	} else {
+		log.SetOutput(out)
+	}
+
+	return log
+}
+
+// Reconfigure applies the level and format of the configuration, so they can
+// change on a configuration reload. The output is only set up by Setup.
+func Reconfigure(log *logrus.Logger, cfg *config.LogConfig) {
 	level, err := logrus.ParseLevel(strings.ToLower(cfg.Level))
 	if err != nil {
 		level = logrus.InfoLevel
 	}
 	log.SetLevel(level)
 
-	// Set log format
 	switch strings.ToLower(cfg.Format) {
 	case "json":
 		log.SetFormatter(&logrus.JSONFormatter{
@@ -33,20 +53,6 @@ func Setup(cfg *config.LogConfig) *logrus.Logger {
 			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
 		})
 	}
-
-	// Set log output
-	if cfg.EnableFile && cfg.File != "" {
-		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err == nil {
-			log.SetOutput(io.MultiWriter(os.Stdout, file))
-		} else {
-			log.Warn("Failed to log to file, using default stderr")
-		}
-	} else {
-		log.SetOutput(os.Stdout)
-	}
-
-	return log
 }
 
 // NewWithFields creates a new logrus entry with pre-defined fields